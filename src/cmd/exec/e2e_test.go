@@ -0,0 +1,303 @@
+package main
+
+import (
+	"../../pkg/pagerdutysvc/pdfake"
+	"../../pkg/postgres"
+	"../../pkg/postgres/postgrestest"
+	"../../pkg/tools"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv wires the transfers to the fake PagerDuty fixtures and a freshly migrated scratch schema
+// in TEST_DATABASE_URL, end-to-end tests are skipped when it isn't set
+func testEnv(t *testing.T) (*Env, *postgres.DB, *pdfake.Fake) {
+
+	db := postgrestest.OpenDB(t)
+
+	tools.EnvironmentVariables.PaginationLimit = 2
+	tools.EnvironmentVariables.PagerDutyEpoch = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	tools.EnvironmentVariables.IncrementalWindow = 90 * 24 * 3600
+	tools.EnvironmentVariables.IncrementalBuffer = 3600
+	tools.EnvironmentVariables.DeadlineMargin = 30
+	tools.EnvironmentVariables.BatchSize = 2
+
+	// Render the 2018 fixture schedules
+	tools.EnvironmentVariables.ScheduleHorizon = 20 * 365 * 24 * 3600
+
+	fake := pdfake.Default()
+
+	return &Env{db: db, pd: fake}, db, fake
+}
+
+func countRows(t *testing.T, db *postgres.DB, table string) int {
+
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func TestSyncEndToEnd(t *testing.T) {
+
+	env, db, _ := testEnv(t)
+
+	result, err := env.HandleRequest(context.Background(), MyEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range result.Entities {
+		assertEqual(t, OutcomeSucceeded, entity.Outcome)
+	}
+
+	for table, expected := range map[string]int{
+		"escalation_policies":       2,
+		"escalation_rules":          3,
+		"escalation_rule_users":     2,
+		"escalation_rule_schedules": 2,
+		"users":                     3,
+		"schedules":                 2,
+		"user_schedule":             3,
+		"services":                  2,
+		"incidents":                 3,
+		"log_entries":               6,
+		"oncall_shifts":             4,
+		"schedule_layers":           3,
+		"schedule_layer_users":      4,
+		"schedule_overrides":        1,
+		"schedule_entries":          5,
+		"teams":                     2,
+		"priorities":                3,
+		"service_integrations":      3,
+		"business_services":         1,
+		"service_dependencies":      2,
+		"urgencies":                 2,
+		"alerts":                    5,
+		"incident_notes":            3,
+		"team_members":              4,
+		"user_contact_methods":      3,
+		"user_notification_rules":   3,
+	} {
+		if count := countRows(t, db, table); count != expected {
+			t.Errorf("Expected %d rows in %s, got %d", expected, table, count)
+		}
+	}
+
+	var resolvedAt time.Time
+	var assignees string
+	err = db.QueryRow("SELECT resolved_at FROM incidents WHERE id = 'PIN0001'").Scan(&resolvedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "2018-03-01T10:42:00Z", resolvedAt.UTC().Format(time.RFC3339))
+	err = db.QueryRow("SELECT array_to_string(assignee_ids, ',') FROM incidents WHERE id = 'PIN0002'").Scan(&assignees)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "PUS0003", assignees)
+
+	var teams string
+	err = db.QueryRow("SELECT array_to_string(team_ids, ',') FROM users WHERE id = 'PUS0001'").Scan(&teams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "PTM0001,PTM0002", teams)
+
+	var vendor, urgency string
+	err = db.QueryRow(`SELECT i.vendor_name, s.urgency_outside_support_hours FROM service_integrations i
+		JOIN services s ON s.id = i.service_id WHERE i.id = 'PIT0001'`).Scan(&vendor, &urgency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "Datadog", vendor)
+	assertEqual(t, "low", urgency)
+
+	var timeZone string
+	err = db.QueryRow("SELECT time_zone FROM users WHERE id = 'PUS0001'").Scan(&timeZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "Europe/London", timeZone)
+
+	cursor, found, err := db.GetCursor("incidents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || cursor.LastError != "" || time.Since(cursor.HighWaterMark) > time.Minute {
+		t.Errorf("Expected the incidents cursor to reach now, got %+v", cursor)
+	}
+
+	// A second run finds nothing new
+	result, err = env.HandleRequest(context.Background(), MyEvent{Entities: []string{"users", "incidents"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range result.Entities {
+		assertEqual(t, 0, entity.Rows.Inserted)
+		assertEqual(t, 0, entity.Rows.Updated)
+	}
+}
+
+func TestTransferUsersMarksDeletedUsers(t *testing.T) {
+
+	env, db, fake := testEnv(t)
+
+	err := TransferUsers(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Users = fake.Users[:2]
+	fake.Users[0].Name = "Ada King"
+
+	result, err := RunTransfer(context.Background(), env, "users", complete(TransferUsers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ada's name changed, Grace, both users' contact methods and notification rules are unchanged
+	assertEqual(t, 1, result.Rows.Updated)
+	assertEqual(t, 7, result.Rows.Unchanged)
+	assertEqual(t, int64(1), result.Rows.Deleted)
+	assertEqual(t, 3, countRows(t, db, "users"))
+
+	var deleted string
+	err = db.QueryRow("SELECT string_agg(id, ',') FROM users WHERE deleted_at IS NOT NULL").Scan(&deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "PUS0003", deleted)
+}
+
+func TestBusinessServicesFailOnTheirOwn(t *testing.T) {
+
+	env, db, fake := testEnv(t)
+	fake.Errors = map[string]error{"ListBusinessServices": errors.New("Failed call API endpoint. HTTP response code: 403. Error: access denied")}
+
+	result, err := env.HandleRequest(context.Background(), MyEvent{Entities: []string{"services", "business_services", "priorities"}})
+	if err == nil {
+		t.Fatal("Expected the business services transfer to fail")
+	}
+
+	// A 403 isn't a configuration error, the entities after it still run
+	assertEqual(t, OutcomeSucceeded, result.Entities[0].Outcome)
+	assertEqual(t, OutcomeFailed, result.Entities[1].Outcome)
+	assertEqual(t, OutcomeSucceeded, result.Entities[2].Outcome)
+	assertEqual(t, 2, countRows(t, db, "services"))
+	assertEqual(t, 0, countRows(t, db, "business_services"))
+}
+
+func TestTransferIncidentsRecordsError(t *testing.T) {
+
+	env, db, fake := testEnv(t)
+	fake.Errors = map[string]error{"ListIncidents": errors.New("Failed call API endpoint. HTTP response code: 400. Error: bad request")}
+
+	result, err := RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if err == nil {
+		t.Fatal("Expected the transfer to fail")
+	}
+
+	assertEqual(t, OutcomeFailed, result.Outcome)
+	assertEqual(t, 1, result.Attempts)
+
+	cursor, found, err := db.GetCursor("incidents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || !strings.Contains(cursor.LastError, "400") {
+		t.Errorf("Expected the error on the cursor, got %+v", cursor)
+	}
+	assertEqual(t, 0, countRows(t, db, "incidents"))
+}
+
+func TestTransferIncidentsLocked(t *testing.T) {
+
+	env, db, fake := testEnv(t)
+
+	// Another invocation is transferring incidents
+	unlock, locked, err := db.LockEntity("incidents")
+	if err != nil || !locked {
+		t.Fatalf("Expected to take the lock, got %v, %v", locked, err)
+	}
+
+	result, err := RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if !errors.Is(err, errEntityLocked) {
+		t.Fatalf("Expected errEntityLocked, got %v", err)
+	}
+	assertEqual(t, OutcomeIncomplete, result.Outcome)
+	assertEqual(t, 0, fake.Calls("ListIncidents"))
+
+	unlock()
+
+	result, err = RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, OutcomeSucceeded, result.Outcome)
+}
+
+func TestTransferIncidentsSkipsResolvedIncidents(t *testing.T) {
+
+	env, db, fake := testEnv(t)
+
+	_, err := RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 3, fake.Calls("ListIncidentAlerts"))
+	assertEqual(t, 3, fake.Calls("ListIncidentNotes"))
+
+	// Fetch every incident again, only the open ones have their alerts and notes fetched again
+	_, err = db.Exec("DELETE FROM sync_state WHERE entity = 'incidents'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 5, fake.Calls("ListIncidentAlerts"))
+	assertEqual(t, 5, fake.Calls("ListIncidentNotes"))
+
+	var details string
+	err = db.QueryRow("SELECT body->'details'->>'host' FROM alerts WHERE id = 'PAL0001'").Scan(&details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "db-1", details)
+}
+
+func TestTransferIncidentsResyncsOpenIncidents(t *testing.T) {
+
+	env, db, fake := testEnv(t)
+
+	_, err := RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every open incident was just listed
+	assertEqual(t, 0, fake.Calls("GetIncident"))
+
+	// PIN0003 was created before the window the next run resumes from
+	fake.Incidents[2].Status = "resolved"
+	fake.Incidents[2].LastStatusChangeAt = "2018-07-04T05:00:00Z"
+
+	_, err = RunTransfer(context.Background(), env, "incidents", TransferIncidents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, fake.Calls("GetIncident"))
+
+	var status string
+	err = db.QueryRow("SELECT status FROM incidents WHERE id = 'PIN0003'").Scan(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "resolved", status)
+}
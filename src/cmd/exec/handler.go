@@ -0,0 +1,335 @@
+package main
+
+import (
+	"../../pkg/pagerdutysvc"
+	"../../pkg/postgres"
+	"../../pkg/tools"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"strings"
+	"time"
+)
+
+// MyEvent selects the entities to sync, e.g. {"entities":["incidents","log_entries"]}.
+// An empty list syncs every entity.
+type MyEvent struct {
+	Entities []string `json:"entities"`
+}
+
+// SyncResult is returned to the Lambda caller after every invocation
+type SyncResult struct {
+	Entities  []EntityResult `json:"entities"`
+	Continued bool           `json:"continued"`
+}
+
+// Outcomes reported per entity
+const (
+	OutcomeSucceeded  = "succeeded"
+	OutcomeIncomplete = "incomplete" // stopped before the deadline, the next invocation resumes from the cursor
+	OutcomeFailed     = "failed"
+	OutcomeAborted    = "aborted" // not attempted because of a configuration error
+)
+
+// errEntityLocked ends a windowed transfer another invocation is already running. The entity is
+// reported incomplete and isn't continued, the invocation holding it does that.
+var errEntityLocked = errors.New("transfer already running in another invocation")
+
+// EntityResult reports the outcome of a single entity transfer. Rows counts what the upserts did
+// to stored rows, SkippedRows counts rows the database rejected with a constraint violation,
+// they don't fail the transfer.
+type EntityResult struct {
+	Entity      string                `json:"entity"`
+	Outcome     string                `json:"outcome"`
+	Attempts    int                   `json:"attempts"`
+	Rows        postgres.UpsertCounts `json:"rows"`
+	SkippedRows int                   `json:"skipped_rows"`
+	Error       string                `json:"error,omitempty"`
+	Duration    float64               `json:"duration_seconds"`
+}
+
+// Invoker starts another invocation of the function asynchronously so an unfinished
+// backfill keeps going without waiting for the next schedule
+type Invoker interface {
+	Invoke(ctx context.Context, event MyEvent) error
+}
+
+// LambdaInvoker re-invokes the running Lambda function with an Event invocation
+type LambdaInvoker struct {
+	FunctionName string
+	AWS          *tools.AWS
+}
+
+func (l *LambdaInvoker) Invoke(ctx context.Context, event MyEvent) error {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return l.AWS.InvokeAsync(ctx, l.FunctionName, payload)
+}
+
+// Transfer functions in the order they are run, keyed by the entity name accepted in MyEvent
+var transfers = []struct {
+	Entity string
+	Run    func(ctx context.Context, env *Env) (bool, error)
+}{
+	{"escalation_policies", complete(TransferEscalationPolicies)},
+	{"users", complete(TransferUsers)},
+	{"teams", complete(TransferTeams)},
+	{"schedules", complete(TransferSchedules)},
+	{"services", complete(TransferServices)},
+	{"business_services", complete(TransferBusinessServices)},
+	{"priorities", complete(TransferPriorities)},
+	{"escalation_rules", complete(TransferEscalationRules)},
+	{"log_entries", TransferLogEntries},
+	{"incidents", TransferIncidents},
+	{"oncall_shifts", TransferOnCallShifts},
+}
+
+// complete adapts a transfer that always runs to the end in a single invocation
+func complete(run func(env *Env) error) func(ctx context.Context, env *Env) (bool, error) {
+	return func(ctx context.Context, env *Env) (bool, error) {
+		return true, run(env)
+	}
+}
+
+// Transfers failing on a transient database error are retried up to maxTransferAttempts times
+const maxTransferAttempts = 3
+
+func (env *Env) HandleRequest(ctx context.Context, event MyEvent) (SyncResult, error) {
+
+	var result SyncResult
+
+	// Cursors record which invocation last moved them
+	env.runID = fmt.Sprintf("local-%d", time.Now().Unix())
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		env.runID = lc.AwsRequestID
+	}
+
+	// A warm Lambda reuses env, every invocation fetches its own escalation policies
+	env.policyIDs = nil
+
+	// PagerDuty requests stop retrying when the invocation runs out of time
+	if client, ok := env.pd.(*pagerdutysvc.Client); ok {
+		client.Bind(ctx)
+	}
+
+	selected, err := SelectEntities(event.Entities)
+	if err != nil {
+		return result, err
+	}
+
+	var failed []string
+	var incomplete []string
+	var abort error
+
+	for i := range transfers {
+		if !selected[transfers[i].Entity] {
+			continue
+		}
+
+		// A configuration error fails every remaining entity the same way, don't bother trying
+		if abort != nil {
+			result.Entities = append(result.Entities, EntityResult{Entity: transfers[i].Entity, Outcome: OutcomeAborted})
+			continue
+		}
+
+		entityResult, err := RunTransfer(ctx, env, transfers[i].Entity, transfers[i].Run)
+		result.Entities = append(result.Entities, entityResult)
+
+		var configErr *tools.ConfigError
+		if errors.As(err, &configErr) {
+			abort = err
+		}
+
+		switch entityResult.Outcome {
+		case OutcomeFailed:
+			failed = append(failed, entityResult.Entity)
+		case OutcomeIncomplete:
+			if !errors.Is(err, errEntityLocked) {
+				incomplete = append(incomplete, entityResult.Entity)
+			}
+		}
+	}
+
+	if abort != nil {
+		return result, fmt.Errorf("data transfer aborted: %w", abort)
+	}
+
+	// Pick up where this invocation left off instead of waiting for the next schedule
+	if len(incomplete) > 0 && env.invoker != nil {
+		err := env.invoker.Invoke(ctx, MyEvent{Entities: incomplete})
+		if err != nil {
+			fmt.Println("Failed to continue transfer:", err)
+		} else {
+			fmt.Println("Continuing transfer in a new invocation for:", strings.Join(incomplete, ", "))
+			result.Continued = true
+		}
+	}
+
+	if len(failed) > 0 {
+		return result, fmt.Errorf("data transfer failed for: %s", strings.Join(failed, ", "))
+	}
+
+	return result, nil
+}
+
+// SelectEntities validates requested entity names, an empty request selects every entity
+func SelectEntities(requested []string) (map[string]bool, error) {
+
+	known := make(map[string]bool)
+	for i := range transfers {
+		known[transfers[i].Entity] = true
+	}
+
+	if len(requested) == 0 {
+		return known, nil
+	}
+
+	selected := make(map[string]bool)
+	for _, entity := range requested {
+		if !known[entity] {
+			return nil, fmt.Errorf("unknown entity %q", entity)
+		}
+		selected[entity] = true
+	}
+
+	return selected, nil
+}
+
+// RunTransfer runs a single transfer, retrying transient database failures, and
+// returns its outcome together with the error that ended it
+func RunTransfer(ctx context.Context, env *Env, entity string, run func(ctx context.Context, env *Env) (bool, error)) (EntityResult, error) {
+
+	result := EntityResult{Entity: entity}
+	start := time.Now()
+	env.skippedRows = 0
+	env.rows = postgres.UpsertCounts{}
+
+	for {
+		result.Attempts++
+
+		finished, err := runRecovered(ctx, env, run)
+		result.SkippedRows = env.skippedRows
+		result.Rows = env.rows
+
+		if err == nil {
+			result.Outcome = OutcomeSucceeded
+			if !finished {
+				result.Outcome = OutcomeIncomplete
+			}
+			result.Duration = time.Since(start).Seconds()
+			return result, nil
+		}
+
+		if errors.Is(err, errEntityLocked) {
+			fmt.Println("Skipping", entity, "transfer:", err)
+			result.Outcome = OutcomeIncomplete
+			result.Error = err.Error()
+			result.Duration = time.Since(start).Seconds()
+			return result, err
+		}
+
+		delay, retry := RetryDelay(err, result.Attempts)
+		if !retry || DeadlineReached(ctx, delay) {
+			fmt.Println("Transfer failed:", entity, err)
+			result.Outcome = OutcomeFailed
+			result.Error = err.Error()
+			result.Duration = time.Since(start).Seconds()
+			return result, err
+		}
+
+		fmt.Println("Retrying", entity, "transfer in", delay, "after:", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// runRecovered converts a panic into an error so that one broken entity doesn't take the remaining ones down with it
+func runRecovered(ctx context.Context, env *Env, run func(ctx context.Context, env *Env) (bool, error)) (finished bool, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return run(ctx, env)
+}
+
+// RetryDelay decides whether a failed transfer is worth retrying and how long to wait first.
+// Rate limited and transient PagerDuty errors aren't: RetryingClient already retried the request
+// for as long as PAGERDUTY_MAX_ATTEMPTS and PAGERDUTY_RETRY_BUDGET allow.
+func RetryDelay(err error, attempt int) (time.Duration, bool) {
+
+	if attempt >= maxTransferAttempts {
+		return 0, false
+	}
+
+	var dbTransientErr *postgres.TransientError
+	if errors.As(err, &dbTransientErr) {
+		return time.Duration(1<<uint(attempt)) * time.Second, true
+	}
+
+	return 0, false
+}
+
+// countRow tallies the outcome of an upsert. Rows the database rejected with a constraint violation
+// are logged and counted as skipped so one bad row doesn't fail the whole transfer, any other
+// error is returned to abort the transfer.
+func (env *Env) countRow(outcome postgres.UpsertOutcome, err error) error {
+
+	var constraintErr *postgres.ConstraintError
+	if errors.As(err, &constraintErr) {
+		fmt.Println("Skipping row:", err)
+		env.skippedRows++
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	env.rows.Add(outcome)
+
+	return nil
+}
+
+// countBulk tallies a bulk upsert the same way countRow tallies a single one
+func (env *Env) countBulk(result postgres.BulkResult, err error) error {
+
+	for _, rejected := range result.Rejected {
+		fmt.Println("Skipping row:", rejected)
+	}
+	env.skippedRows += len(result.Rejected)
+	env.rows.Inserted += result.Inserted
+	env.rows.Updated += result.Updated
+	env.rows.Unchanged += result.Unchanged
+
+	return err
+}
+
+// deleteStaleScheduleRows removes a schedule's overrides or entries a refresh didn't see any more and counts them
+func (env *Env) deleteStaleScheduleRows(TableName string, scheduleID string, since time.Time, ids []string) error {
+
+	count, err := env.db.DeleteStaleScheduleRows(TableName, scheduleID, since, ids)
+	env.rows.Deleted += count
+
+	return err
+}
+
+// deleteStaleRows removes rows a dimension refresh didn't see any more and counts them
+func (env *Env) deleteStaleRows(TableName string, ids []string) error {
+
+	count, err := env.db.DeleteStaleRows(TableName, ids)
+	env.rows.Deleted += count
+
+	return err
+}
@@ -8,46 +8,127 @@ import (
 	"fmt"
 	"github.com/PagerDuty/go-pagerduty"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"log"
+	"os"
 	"time"
 )
 
-type MyEvent struct {
-	Name string `json:"name"`
-}
-
-// links reportingstore interface
+// links reportingstore interface, invoker is optional and continues unfinished backfills
 type Env struct {
-	db postgres.ReportingStore
+	db          postgres.ReportingStore
+	pd          pagerdutysvc.Source
+	invoker     Invoker
+	runID       string
+	skippedRows int
+	rows        postgres.UpsertCounts
+
+	// IDs of the escalation policies fetched during this invocation, see escalationPolicyIDs
+	policyIDs []string
 }
 
-func HandleRequest(ctx context.Context, name MyEvent) (string, error) {
-	return fmt.Sprintf("Data transfer completed successfully"), nil
+// escalationPolicyIDs lists the escalation policies once per invocation, the escalation rules
+// transfer reuses the IDs the escalation policies transfer stored
+func (env *Env) escalationPolicyIDs() ([]string, error) {
+
+	if env.policyIDs != nil {
+		return env.policyIDs, nil
+	}
+
+	ids := []string{}
+	err := pagerdutysvc.EachEscalationPolicyPage(env.pd, func(page []pagerduty.EscalationPolicy) error {
+		for i := range page {
+			ids = append(ids, page[i].APIObject.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	env.policyIDs = ids
+
+	return env.policyIDs, nil
 }
 
-func TransferEscalationPolicies(env *Env) {
+func TransferEscalationPolicies(env *Env) error {
+
+	ids := []string{}
+
+	err := env.refresh(func(env *Env) error {
+		batch := []tools.EscalationsPolicy{}
+		err := pagerdutysvc.EachEscalationPolicyPage(env.pd, func(page []pagerduty.EscalationPolicy) error {
+			for i := range page {
+				ids = append(ids, page[i].APIObject.ID)
+			}
+			batch = append(batch, tools.GetMappedEscalationPolicies(page)...)
+			if !BatchFull(len(batch)) {
+				return nil
+			}
 
-	EscalationsPolicies := pagerdutysvc.GetPagerDutyEscalationPolicies()
-	MappedEscalationPolicies := tools.GetMappedEscalationPolicies(EscalationsPolicies)
+			err := env.countBulk(env.db.BulkUpsertEscalationPolicies(batch))
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return err
+		}
 
-	env.db.TruncateTable("escalation_policies")
+		err = env.countBulk(env.db.BulkUpsertEscalationPolicies(batch))
+		if err != nil {
+			return err
+		}
 
-	for i := range MappedEscalationPolicies {
-		env.db.UpdateEscalationPolicies(MappedEscalationPolicies[i])
+		return env.deleteStaleRows("escalation_policies", ids)
+	})
+	if err != nil {
+		return err
 	}
+	env.policyIDs = ids
+
+	return nil
 }
 
-func TransferSchedules(env *Env) {
-	Schedules := pagerdutysvc.GetPagerDutySchedules()
-	MappedSchedules := tools.GetMappedSchedules(Schedules)
+// scheduleTables are the tables TransferSchedules refreshes whole, overrides and entries are
+// refreshed schedule by schedule
+var scheduleTables = []string{"schedules", "user_schedule", "schedule_layers", "schedule_layer_users"}
 
-	MappedUserSchedules := []tools.UserSchedule{}
+// TransferSchedules refreshes the schedules with their layers, and renders each of them over
+// SCHEDULE_HORIZON seconds either side of today for the overrides and final schedule entries
+func TransferSchedules(env *Env) error {
 
-	env.db.TruncateTable("schedules")
-	env.db.TruncateTable("user_schedule")
+	horizon := ScheduleHorizon(time.Now())
 
-	for i := range MappedSchedules {
-		env.db.UpdateSchedules(MappedSchedules[i])
-	}
+	return env.refresh(func(env *Env) error {
+		ids := make(map[string][]string)
+		for _, table := range scheduleTables {
+			ids[table] = []string{}
+		}
+
+		err := pagerdutysvc.EachSchedulePage(env.pd, func(page []pagerduty.Schedule) error {
+			return StoreSchedules(env, page, horizon, ids)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, table := range scheduleTables {
+			err := env.deleteStaleRows(table, ids[table])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// StoreSchedules writes a page of schedules with their layers, overrides and entries over horizon,
+// and records the IDs written in ids by table
+func StoreSchedules(env *Env, Schedules []pagerduty.Schedule, horizon pagerdutysvc.Window, ids map[string][]string) error {
+
+	MappedSchedules := tools.GetMappedSchedules(Schedules)
+
+	MappedUserSchedules := []tools.UserSchedule{}
 
 	// Loop over mapped schedules again extract user IDs and build UserSchedule mapping
 
@@ -70,46 +151,184 @@ func TransferSchedules(env *Env) {
 
 	}
 
+	// Schedules are rendered concurrently, each into its own slot
+	Rendered := make([]*pagerduty.Schedule, len(Schedules))
+	ScheduleOverrides := make([][]pagerduty.Override, len(Schedules))
+	err := pagerdutysvc.ForEach(len(Schedules), func(i int) error {
+		scheduleID := Schedules[i].APIObject.ID
+
+		Schedule, err := pagerdutysvc.GetPagerDutySchedule(env.pd, scheduleID, horizon)
+		if err != nil {
+			return err
+		}
+		Rendered[i] = Schedule
+
+		ScheduleOverrides[i], err = pagerdutysvc.GetPagerDutyOverrides(env.pd, scheduleID, horizon)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	MappedLayers := []tools.ScheduleLayer{}
+	MappedLayerUsers := []tools.ScheduleLayerUser{}
+	MappedOverrides := make(map[string][]tools.ScheduleOverride)
+	MappedEntries := make(map[string][]tools.ScheduleEntry)
+
+	for i := range Schedules {
+
+		scheduleID := Schedules[i].APIObject.ID
+
+		layers, layerUsers := tools.GetMappedScheduleLayers(*Rendered[i])
+		MappedLayers = append(MappedLayers, layers...)
+		MappedLayerUsers = append(MappedLayerUsers, layerUsers...)
+		MappedEntries[scheduleID] = EntriesAfter(tools.GetMappedScheduleEntries(*Rendered[i]), horizon.Since)
+		MappedOverrides[scheduleID] = tools.GetMappedScheduleOverrides(ScheduleOverrides[i], scheduleID)
+	}
+
+	for i := range MappedSchedules {
+		ids["schedules"] = append(ids["schedules"], MappedSchedules[i].APIObject.ID)
+		err := env.countRow(env.db.UpdateSchedules(MappedSchedules[i]))
+		if err != nil {
+			return err
+		}
+	}
+
 	for i := range MappedUserSchedules {
-		env.db.UpdateUserSchedules(MappedUserSchedules[i])
+		ids["user_schedule"] = append(ids["user_schedule"], MappedUserSchedules[i].ID)
+		err := env.countRow(env.db.UpdateUserSchedules(MappedUserSchedules[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range MappedLayers {
+		ids["schedule_layers"] = append(ids["schedule_layers"], MappedLayers[i].ID)
+		err := env.countRow(env.db.UpdateScheduleLayers(MappedLayers[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range MappedLayerUsers {
+		ids["schedule_layer_users"] = append(ids["schedule_layer_users"], MappedLayerUsers[i].ID)
+		err := env.countRow(env.db.UpdateScheduleLayerUsers(MappedLayerUsers[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range Schedules {
+		scheduleID := Schedules[i].APIObject.ID
+
+		overrideIDs := []string{}
+		for i := range MappedOverrides[scheduleID] {
+			overrideIDs = append(overrideIDs, MappedOverrides[scheduleID][i].ID)
+		}
+		err := env.countBulk(env.db.BulkUpsertScheduleOverrides(MappedOverrides[scheduleID]))
+		if err != nil {
+			return err
+		}
+		err = env.deleteStaleScheduleRows("schedule_overrides", scheduleID, horizon.Since, overrideIDs)
+		if err != nil {
+			return err
+		}
+
+		entryIDs := []string{}
+		for i := range MappedEntries[scheduleID] {
+			entryIDs = append(entryIDs, MappedEntries[scheduleID][i].ID)
+		}
+		err = env.countBulk(env.db.BulkUpsertScheduleEntries(MappedEntries[scheduleID]))
+		if err != nil {
+			return err
+		}
+		err = env.deleteStaleScheduleRows("schedule_entries", scheduleID, horizon.Since, entryIDs)
+		if err != nil {
+			return err
+		}
 	}
 
+	return nil
 }
 
-func TransferEscalationRules(env *Env) {
+// ScheduleHorizon is the range schedules are rendered over, SCHEDULE_HORIZON seconds either side of
+// now. Both ends are whole days so consecutive runs request the same range.
+func ScheduleHorizon(now time.Time) pagerdutysvc.Window {
+
+	horizon := time.Duration(tools.EnvironmentVariables.ScheduleHorizon) * time.Second
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	return pagerdutysvc.NewWindow(today.Add(-horizon).Truncate(24*time.Hour), today.Add(horizon+24*time.Hour).Truncate(24*time.Hour))
+}
 
-	env.db.TruncateTable("escalation_rules")
-	env.db.TruncateTable("escalation_rule_schedules")
-	env.db.TruncateTable("escalation_rule_users")
+// EntriesAfter drops entries starting at or before since. The API cuts the entry running at since
+// to start there, the entry itself was stored with its real start by an earlier run.
+func EntriesAfter(entries []tools.ScheduleEntry, since time.Time) []tools.ScheduleEntry {
 
-	// Retrieve escalation policies
-	EscalationsPolicies := pagerdutysvc.GetPagerDutyEscalationPolicies()
+	after := []tools.ScheduleEntry{}
+	for i := range entries {
+		start, err := time.Parse(time.RFC3339, entries[i].Start)
+		if err == nil && !start.After(since) {
+			continue
+		}
+		after = append(after, entries[i])
+	}
+
+	return after
+}
+
+func TransferEscalationRules(env *Env) error {
+
+	// Retrieve escalation policies, unless the escalation policies transfer already did
+	EscalationsPolicyIDs, err := env.escalationPolicyIDs()
+	if err != nil {
+		return err
+	}
+
+	PolicyRules, err := pagerdutysvc.GetPagerDutyEscalationRules(env.pd, EscalationsPolicyIDs)
+	if err != nil {
+		return err
+	}
 	EscalationsRulesSlice := []pagerduty.EscalationRule{}
 	var MappedEscalationRules = []tools.EscalationsRule{}
 
 	// Map Escalation Rules to Escalation Policy
-	for i := range EscalationsPolicies {
-		EscalationsPolicyID := EscalationsPolicies[i].APIObject.ID
-
-		EscalationsRules := pagerdutysvc.GetPagerDutyEscalationRule(EscalationsPolicyID)
+	for i, EscalationsRules := range PolicyRules {
 
 		// Append API response to slice for future use
 		EscalationsRulesSlice = append(EscalationsRulesSlice, EscalationsRules...)
 
-		MappedEscalationRules = append(tools.GetMappedEscalationRules(EscalationsRules, EscalationsPolicyID), MappedEscalationRules...)
-
-	}
+		MappedEscalationRules = append(tools.GetMappedEscalationRules(EscalationsRules, EscalationsPolicyIDs[i]), MappedEscalationRules...)
 
-	for i := range MappedEscalationRules {
-		env.db.UpdateEscalationRules(MappedEscalationRules[i])
 	}
 
 	// Map Escalation Rules to User IDs and Schedule IDs
 	EscalationRuleUserStruct := ExtractEscalationRulesUser(EscalationsRulesSlice)
-	TransferEscalationRulesUser(env, EscalationRuleUserStruct)
-
 	EscalationRuleScheduleStruct := ExtractEscalationRulesSchedule(EscalationsRulesSlice)
-	TransferEscalationRulesSchedule(env, EscalationRuleScheduleStruct)
+
+	return env.refresh(func(env *Env) error {
+		ids := []string{}
+
+		for i := range MappedEscalationRules {
+			ids = append(ids, MappedEscalationRules[i].ID)
+			err := env.countRow(env.db.UpdateEscalationRules(MappedEscalationRules[i]))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := env.deleteStaleRows("escalation_rules", ids)
+		if err != nil {
+			return err
+		}
+
+		err = TransferEscalationRulesUser(env, EscalationRuleUserStruct)
+		if err != nil {
+			return err
+		}
+
+		return TransferEscalationRulesSchedule(env, EscalationRuleScheduleStruct)
+	})
 
 }
 
@@ -140,13 +359,19 @@ func ExtractEscalationRulesUser(EscalationRules []pagerduty.EscalationRule) []to
 
 }
 
-func TransferEscalationRulesUser(env *Env, EscalationRuleUsers []tools.EscalationsRuleUser) {
+func TransferEscalationRulesUser(env *Env, EscalationRuleUsers []tools.EscalationsRuleUser) error {
+
+	ids := []string{}
 
 	for i := range EscalationRuleUsers {
-		// fmt.Printf("%+v\n", EscalationRuleUsers[i])
-		env.db.UpdateEscalationRuleUsers(EscalationRuleUsers[i])
+		ids = append(ids, EscalationRuleUsers[i].ID)
+		err := env.countRow(env.db.UpdateEscalationRuleUsers(EscalationRuleUsers[i]))
+		if err != nil {
+			return err
+		}
 	}
 
+	return env.deleteStaleRows("escalation_rule_users", ids)
 }
 
 func ExtractEscalationRulesSchedule(EscalationRules []pagerduty.EscalationRule) []tools.EscalationsRuleSchedule {
@@ -176,36 +401,289 @@ func ExtractEscalationRulesSchedule(EscalationRules []pagerduty.EscalationRule)
 
 }
 
-func TransferEscalationRulesSchedule(env *Env, EscalationRuleSchedules []tools.EscalationsRuleSchedule) {
+func TransferEscalationRulesSchedule(env *Env, EscalationRuleSchedules []tools.EscalationsRuleSchedule) error {
+
+	ids := []string{}
 
 	for i := range EscalationRuleSchedules {
-		// fmt.Printf("%+v\n", EscalationRuleSchedules[i])
-		env.db.UpdateEscalationRuleSchedules(EscalationRuleSchedules[i])
+		ids = append(ids, EscalationRuleSchedules[i].ID)
+		err := env.countRow(env.db.UpdateEscalationRuleSchedules(EscalationRuleSchedules[i]))
+		if err != nil {
+			return err
+		}
 	}
 
+	return env.deleteStaleRows("escalation_rule_schedules", ids)
+}
+
+// TransferTeams refreshes the teams and their members, with each member's role
+func TransferTeams(env *Env) error {
+
+	return env.refresh(func(env *Env) error {
+		teamIDs := []string{}
+		teamMemberIDs := []string{}
+
+		err := pagerdutysvc.EachTeamPage(env.pd, func(Teams []pagerduty.Team) error {
+			MappedTeams := tools.GetMappedTeams(Teams)
+
+			TeamMembers := make([][]tools.TeamMember, len(Teams))
+			err := pagerdutysvc.ForEach(len(Teams), func(i int) error {
+				Members, err := pagerdutysvc.GetPagerDutyTeamMembers(env.pd, Teams[i].APIObject.ID)
+				TeamMembers[i] = tools.GetMappedTeamMembers(Members, Teams[i].APIObject.ID)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			for i := range MappedTeams {
+				teamIDs = append(teamIDs, MappedTeams[i].APIObject.ID)
+				err := env.countRow(env.db.UpdateTeams(MappedTeams[i]))
+				if err != nil {
+					return err
+				}
+			}
+
+			for i := range TeamMembers {
+				for y := range TeamMembers[i] {
+					teamMemberIDs = append(teamMemberIDs, TeamMembers[i][y].ID)
+					err := env.countRow(env.db.UpdateTeamMembers(TeamMembers[i][y]))
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = env.deleteStaleRows("teams", teamIDs)
+		if err != nil {
+			return err
+		}
+
+		return env.deleteStaleRows("team_members", teamMemberIDs)
+	})
 }
 
-func TransferUsers(env *Env) {
-	Users := pagerdutysvc.GetPagerDutyUsers()
-	MappedUsers := tools.GetMappedUsers(Users)
-	env.db.TruncateTable("users")
+func TransferUsers(env *Env) error {
+
+	return env.refresh(func(env *Env) error {
+		ids := []string{}
+		contactMethodIDs := []string{}
+		notificationRuleIDs := []string{}
+
+		batch := []tools.User{}
+		err := pagerdutysvc.EachUserPage(env.pd, func(Users []tools.PagerDutyUser) error {
+			MappedContactMethods := tools.GetMappedUserContactMethods(Users)
+			MappedNotificationRules := tools.GetMappedUserNotificationRules(Users)
+
+			for i := range MappedContactMethods {
+				contactMethodIDs = append(contactMethodIDs, MappedContactMethods[i].ID)
+				err := env.countRow(env.db.UpdateUserContactMethods(MappedContactMethods[i]))
+				if err != nil {
+					return err
+				}
+			}
+
+			for i := range MappedNotificationRules {
+				notificationRuleIDs = append(notificationRuleIDs, MappedNotificationRules[i].ID)
+				err := env.countRow(env.db.UpdateUserNotificationRules(MappedNotificationRules[i]))
+				if err != nil {
+					return err
+				}
+			}
+
+			MappedUsers := tools.GetMappedUsers(Users)
+			for i := range MappedUsers {
+				ids = append(ids, MappedUsers[i].APIObject.ID)
+			}
+			batch = append(batch, MappedUsers...)
+			if !BatchFull(len(batch)) {
+				return nil
+			}
+
+			err := env.countBulk(env.db.BulkUpsertUsers(batch))
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		err = env.countBulk(env.db.BulkUpsertUsers(batch))
+		if err != nil {
+			return err
+		}
+
+		err = env.deleteStaleRows("user_contact_methods", contactMethodIDs)
+		if err != nil {
+			return err
+		}
+		err = env.deleteStaleRows("user_notification_rules", notificationRuleIDs)
+		if err != nil {
+			return err
+		}
 
-	for i := range MappedUsers {
-		env.db.UpdateUsers(MappedUsers[i])
+		// Users removed from PagerDuty are kept and marked deleted
+		count, err := env.db.MarkUsersDeleted(ids)
+		env.rows.Deleted += count
+
+		return err
+	})
+}
+
+// TransferPriorities refreshes the incident priorities incidents.priority_id refers to
+func TransferPriorities(env *Env) error {
+	Priorities, err := pagerdutysvc.GetPagerDutyPriorities(env.pd)
+	if err != nil {
+		return err
 	}
+	MappedPriorities := tools.GetMappedPriorities(Priorities)
+
+	return env.refresh(func(env *Env) error {
+		ids := []string{}
+
+		for i := range MappedPriorities {
+			ids = append(ids, MappedPriorities[i].APIObject.ID)
+			err := env.countRow(env.db.UpdatePriorities(MappedPriorities[i]))
+			if err != nil {
+				return err
+			}
+		}
+
+		return env.deleteStaleRows("priorities", ids)
+	})
+}
+
+// TransferServices refreshes the technical services with their integrations
+func TransferServices(env *Env) error {
+
+	return env.refresh(func(env *Env) error {
+		serviceIDs := []string{}
+		integrationIDs := []string{}
+
+		batch := []tools.Service{}
+		err := pagerdutysvc.EachServicePage(env.pd, func(Services []pagerduty.Service) error {
+			MappedIntegrations := tools.GetMappedServiceIntegrations(Services)
+
+			for i := range MappedIntegrations {
+				integrationIDs = append(integrationIDs, MappedIntegrations[i].ID)
+				err := env.countRow(env.db.UpdateServiceIntegrations(MappedIntegrations[i]))
+				if err != nil {
+					return err
+				}
+			}
+
+			MappedServices := tools.GetMappedServices(Services)
+			for i := range MappedServices {
+				serviceIDs = append(serviceIDs, MappedServices[i].APIObject.ID)
+			}
+			batch = append(batch, MappedServices...)
+			if !BatchFull(len(batch)) {
+				return nil
+			}
+
+			err := env.countBulk(env.db.BulkUpsertServices(batch))
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		err = env.countBulk(env.db.BulkUpsertServices(batch))
+		if err != nil {
+			return err
+		}
+
+		err = env.deleteStaleRows("services", serviceIDs)
+		if err != nil {
+			return err
+		}
+
+		return env.deleteStaleRows("service_integrations", integrationIDs)
+	})
 }
 
-func TransferServices(env *Env) {
-	Services := pagerdutysvc.GetPagerDutyServices()
-	MappedServices := tools.GetMappedServices(Services)
-	env.db.TruncateTable("services")
+// TransferBusinessServices refreshes the business services and the dependencies of every business and
+// technical service. It's an entity of its own so an account without access to business services or
+// dependencies still gets its services; the technical services are those stored by TransferServices.
+func TransferBusinessServices(env *Env) error {
 
-	for i := range MappedServices {
-		env.db.UpdateServices(MappedServices[i])
+	serviceIDs, err := env.db.ServiceIDs()
+	if err != nil {
+		return err
 	}
+
+	return env.refresh(func(env *Env) error {
+		businessServiceIDs := []string{}
+		dependencyIDs := []string{}
+
+		err := pagerdutysvc.EachBusinessServicePage(env.pd, func(BusinessServices []tools.PagerDutyBusinessService) error {
+			MappedBusinessServices := tools.GetMappedBusinessServices(BusinessServices)
+
+			for i := range MappedBusinessServices {
+				businessServiceIDs = append(businessServiceIDs, MappedBusinessServices[i].APIObject.ID)
+				err := env.countRow(env.db.UpdateBusinessServices(MappedBusinessServices[i]))
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		TechnicalDependencies, err := pagerdutysvc.GetPagerDutyServiceDependencies(env.pd, pagerdutysvc.TechnicalServices, serviceIDs)
+		if err != nil {
+			return err
+		}
+		BusinessDependencies, err := pagerdutysvc.GetPagerDutyServiceDependencies(env.pd, pagerdutysvc.BusinessServices, businessServiceIDs)
+		if err != nil {
+			return err
+		}
+		MappedDependencies := tools.GetMappedServiceDependencies(append(TechnicalDependencies, BusinessDependencies...))
+
+		for i := range MappedDependencies {
+			dependencyIDs = append(dependencyIDs, MappedDependencies[i].ID)
+			err := env.countRow(env.db.UpdateServiceDependencies(MappedDependencies[i]))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = env.deleteStaleRows("business_services", businessServiceIDs)
+		if err != nil {
+			return err
+		}
+
+		return env.deleteStaleRows("service_dependencies", dependencyIDs)
+	})
+}
+
+// refresh upserts dimension tables and deletes stale rows inside a single transaction. fn gets a copy
+// of env bound to the transaction, a failed refresh rolls back and readers keep the previous snapshot.
+func (env *Env) refresh(fn func(env *Env) error) error {
+
+	return env.db.InTransaction(func(store postgres.ReportingStore) error {
+		txEnv := *env
+		txEnv.db = store
+
+		err := fn(&txEnv)
+		env.skippedRows = txEnv.skippedRows
+		env.rows = txEnv.rows
+
+		return err
+	})
 }
 
-func TransferIncidents(env *Env) {
+func TransferIncidents(ctx context.Context, env *Env) (bool, error) {
 
 	/*
 		Update data in windowed time chunks. This will give us manageable
@@ -215,23 +693,244 @@ func TransferIncidents(env *Env) {
 		log("refresh_incremental.window", collection: collection, since: since.iso8601, through: through.iso8601)
 	*/
 
-	dateFrom := env.db.CalcLastIncidentRecordDate()
-	dateTo := dateFrom.Add(time.Duration(tools.EnvironmentVariables.IncrementalWindow) * time.Second)
+	// Windows are keyed on created_at, incidents changing state later are fetched again by ID: those
+	// with log entries in a window, and those still open once every window is done
+	fetched := make(map[string]bool)
+
+	done, err := TransferWindows(ctx, env, "incidents", func(window pagerdutysvc.Window) (time.Duration, error) {
+		batch := []tools.Incident{}
+		size, err := pagerdutysvc.EachIncidentPage(env.pd, window, func(page []pagerduty.Incident) error {
+			for _, incident := range tools.GetMappedIncidents(page) {
+				// Pages of a window that had to be split come again from its first half
+				if fetched[incident.APIObject.ID] {
+					continue
+				}
+				fetched[incident.APIObject.ID] = true
+				batch = append(batch, incident)
+			}
+			if !BatchFull(len(batch)) {
+				return nil
+			}
 
-	for time.Now().After(dateFrom) {
-		Incidents := pagerdutysvc.GetPagerDutyIncidents(dateFrom, dateTo)
-		MappedIncidents := tools.GetMappedIncidents(Incidents)
+			err := StoreIncidents(env, batch, nil)
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
 
-		for i := range MappedIncidents {
-			env.db.UpdateIncidents(MappedIncidents[i])
+		err = StoreIncidents(env, batch, nil)
+		if err != nil {
+			return 0, err
 		}
 
-		dateFrom = dateTo
-		dateTo = dateFrom.Add(time.Duration(tools.EnvironmentVariables.IncrementalWindow) * time.Second)
+		logged, err := env.db.LoggedIncidentIDs(window.Since, window.Until)
+		if err != nil {
+			return 0, err
+		}
+
+		// Adding a note changes nothing else on an incident, their notes are fetched again regardless
+		annotated, err := env.db.AnnotatedIncidentIDs(window.Since, window.Until)
+		if err != nil {
+			return 0, err
+		}
+
+		return size, ResyncIncidents(env, ResyncPending(logged, fetched), fetched, IDSet(annotated))
+	})
+	if !done || err != nil {
+		return done, err
 	}
+
+	open, err := env.db.OpenIncidentIDs()
+	if err != nil {
+		return false, err
+	}
+
+	return true, ResyncIncidents(env, ResyncPending(open, fetched), fetched, nil)
 }
 
-func TransferLogEntries(env *Env) {
+// StoreIncidents upserts incidents together with their alerts and notes, the notes of incidents in
+// annotated are fetched even when they look unchanged
+func StoreIncidents(env *Env, incidents []tools.Incident, annotated map[string]bool) error {
+
+	err := env.countBulk(env.db.BulkUpsertIncidents(incidents))
+	if err != nil {
+		return err
+	}
+
+	err = TransferAlerts(env, incidents)
+	if err != nil {
+		return err
+	}
+
+	return TransferIncidentNotes(env, incidents, annotated)
+}
+
+// ResyncIncidents fetches the incidents in ids by ID and stores their current state
+func ResyncIncidents(env *Env, ids []string, fetched map[string]bool, annotated map[string]bool) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+	fmt.Println("Re-syncing", len(ids), "incidents")
+
+	Incidents, err := pagerdutysvc.GetPagerDutyIncidentsByID(env.pd, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fetched[id] = true
+	}
+
+	return StoreIncidents(env, tools.GetMappedIncidents(Incidents), annotated)
+}
+
+// IDSet indexes ids for lookups
+func IDSet(ids []string) map[string]bool {
+
+	set := make(map[string]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+// ResyncPending returns ids without duplicates and without the incidents already fetched in this run
+func ResyncPending(ids []string, fetched map[string]bool) []string {
+
+	pending := []string{}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if fetched[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		pending = append(pending, id)
+	}
+
+	return pending
+}
+
+// TransferAlerts stores the alerts of incidents, unless the incident is resolved and hasn't changed
+// since its alerts were last fetched
+func TransferAlerts(env *Env, incidents []tools.Incident) error {
+
+	ids := []string{}
+	for i := range incidents {
+		ids = append(ids, incidents[i].APIObject.ID)
+	}
+
+	syncedAt, err := env.db.IncidentsSyncedAt(postgres.AlertsSyncedAt, ids)
+	if err != nil {
+		return err
+	}
+	pending := AlertsPending(incidents, syncedAt)
+
+	IncidentAlerts := make([][]tools.PagerDutyAlert, len(pending))
+	err = pagerdutysvc.ForEach(len(pending), func(i int) error {
+		var err error
+		IncidentAlerts[i], err = pagerdutysvc.GetPagerDutyIncidentAlerts(env.pd, pending[i].APIObject.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	Alerts := []tools.PagerDutyAlert{}
+	for i := range IncidentAlerts {
+		Alerts = append(Alerts, IncidentAlerts[i]...)
+	}
+	MappedAlerts := tools.GetMappedAlerts(Alerts)
+
+	err = env.countBulk(env.db.BulkUpsertAlerts(MappedAlerts))
+	if err != nil {
+		return err
+	}
+
+	return env.db.SetIncidentsSyncedAt(postgres.AlertsSyncedAt, pending)
+}
+
+// TransferIncidentNotes stores the notes of incidents that are new, still open, changed since their
+// notes were last fetched, updated within INCREMENTAL_BUFFER or in annotated
+func TransferIncidentNotes(env *Env, incidents []tools.Incident, annotated map[string]bool) error {
+
+	ids := []string{}
+	for i := range incidents {
+		ids = append(ids, incidents[i].APIObject.ID)
+	}
+
+	syncedAt, err := env.db.IncidentsSyncedAt(postgres.NotesSyncedAt, ids)
+	if err != nil {
+		return err
+	}
+	pending := NotesPending(incidents, syncedAt, annotated, time.Now())
+
+	IncidentNotes := make([][]tools.IncidentNote, len(pending))
+	err = pagerdutysvc.ForEach(len(pending), func(i int) error {
+		Notes, err := pagerdutysvc.GetPagerDutyIncidentNotes(env.pd, pending[i].APIObject.ID)
+		IncidentNotes[i] = tools.GetMappedIncidentNotes(Notes, pending[i].APIObject.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	MappedNotes := []tools.IncidentNote{}
+	for i := range IncidentNotes {
+		MappedNotes = append(MappedNotes, IncidentNotes[i]...)
+	}
+
+	err = env.countBulk(env.db.BulkUpsertIncidentNotes(MappedNotes))
+	if err != nil {
+		return err
+	}
+
+	return env.db.SetIncidentsSyncedAt(postgres.NotesSyncedAt, pending)
+}
+
+// AlertsPending picks the incidents whose alerts need fetching: those never fetched, not resolved yet,
+// or whose status changed since syncedAt
+func AlertsPending(incidents []tools.Incident, syncedAt map[string]time.Time) []tools.Incident {
+
+	pending := []tools.Incident{}
+	for i := range incidents {
+		at, synced := syncedAt[incidents[i].APIObject.ID]
+		changedAt, err := time.Parse(time.RFC3339, incidents[i].LastStatusChangeAt)
+
+		if synced && err == nil && incidents[i].Status == "resolved" && changedAt.Equal(at) {
+			continue
+		}
+		pending = append(pending, incidents[i])
+	}
+
+	return pending
+}
+
+// NotesPending picks the incidents whose notes need fetching. Notes can be added after an incident
+// is resolved, so incidents updated within INCREMENTAL_BUFFER of now and those in annotated are
+// fetched again as well.
+func NotesPending(incidents []tools.Incident, syncedAt map[string]time.Time, annotated map[string]bool, now time.Time) []tools.Incident {
+
+	buffer := time.Duration(tools.EnvironmentVariables.IncrementalBuffer) * time.Second
+
+	pending := []tools.Incident{}
+	for i := range incidents {
+		at, synced := syncedAt[incidents[i].APIObject.ID]
+		changedAt, err := time.Parse(time.RFC3339, incidents[i].LastStatusChangeAt)
+
+		if synced && err == nil && incidents[i].Status == "resolved" && changedAt.Equal(at) &&
+			changedAt.Before(now.Add(-buffer)) && !annotated[incidents[i].APIObject.ID] {
+			continue
+		}
+		pending = append(pending, incidents[i])
+	}
+
+	return pending
+}
+
+func TransferLogEntries(ctx context.Context, env *Env) (bool, error) {
 
 	/*
 		Update data in windowed time chunks. This will give us manageable
@@ -241,27 +940,189 @@ func TransferLogEntries(env *Env) {
 		log("refresh_incremental.window", collection: collection, since: since.iso8601, through: through.iso8601)
 	*/
 
-	dateFrom := env.db.CalcLastLogEntryRecordDate()
-	dateTo := dateFrom.Add(time.Duration(tools.EnvironmentVariables.IncrementalWindow) * time.Second)
+	return TransferWindows(ctx, env, "log_entries", func(window pagerdutysvc.Window) (time.Duration, error) {
+		batch := []tools.LogEntry{}
+		size, err := pagerdutysvc.EachLogEntryPage(env.pd, window, func(page []tools.PagerDutyLogEntry) error {
+			batch = append(batch, tools.GetMappedLogEntries(page)...)
+			if !BatchFull(len(batch)) {
+				return nil
+			}
+
+			err := env.countBulk(env.db.BulkUpsertLogEntries(batch))
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return size, env.countBulk(env.db.BulkUpsertLogEntries(batch))
+	})
+}
+
+// TransferOnCallShifts stores who was on call for which escalation policy and level. The API returns
+// every shift overlapping a window whole, shifts spanning several windows are upserted once per window.
+func TransferOnCallShifts(ctx context.Context, env *Env) (bool, error) {
+
+	return TransferWindows(ctx, env, "oncall_shifts", func(window pagerdutysvc.Window) (time.Duration, error) {
+		batch := []tools.OnCallShift{}
+		err := pagerdutysvc.EachOnCallPage(env.pd, window, func(page []pagerduty.OnCall) error {
+			batch = append(batch, tools.GetMappedOnCallShifts(page)...)
+			if !BatchFull(len(batch)) {
+				return nil
+			}
+
+			err := env.countBulk(env.db.BulkUpsertOnCallShifts(batch))
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return window.Duration(), env.countBulk(env.db.BulkUpsertOnCallShifts(batch))
+	})
+}
+
+// BatchFull tells whether a buffer of mapped rows reached BULK_BATCH_SIZE and should be written.
+// Windowed transfers store their pages a batch at a time, a window is never held in memory whole.
+func BatchFull(rows int) bool {
+
+	return rows >= tools.EnvironmentVariables.BatchSize
+}
+
+// ResumeDate rewinds the stored high-water mark by INCREMENTAL_BUFFER to make sure we don't miss
+// anything, an entity that was never synced starts at PAGERDUTY_EPOCH
+func ResumeDate(cursor postgres.Cursor, found bool) time.Time {
+
+	if !found {
+		return tools.EnvironmentVariables.PagerDutyEpoch
+	}
+
+	return cursor.HighWaterMark.Add(time.Duration(-tools.EnvironmentVariables.IncrementalBuffer) * time.Second)
+}
+
+// NextWindow is the size of the window after one transfer settled on settled: the size it had to
+// split down to, or twice the current size, up to INCREMENTAL_WINDOW, when it didn't need splitting
+func NextWindow(window time.Duration, settled time.Duration) time.Duration {
+
+	maxWindow := time.Duration(tools.EnvironmentVariables.IncrementalWindow) * time.Second
+
+	switch {
+	case settled > 0 && settled < window:
+		return settled
+	case window*2 > maxWindow || window <= 0:
+		return maxWindow
+	default:
+		return window * 2
+	}
+}
+
+// TransferWindows walks from the stored cursor up to now in chunks of at most INCREMENTAL_WINDOW and
+// advances the cursor after every finished window, a failed window is recorded as the cursor's
+// last error. transfer returns the window size it settled on, windows it had to split shrink the
+// following ones and the size is stored with the cursor for the next run. It returns false when it
+// stopped early because the next window would not finish before the context deadline, and
+// errEntityLocked when another invocation is transferring entity.
+func TransferWindows(ctx context.Context, env *Env, entity string, transfer func(window pagerdutysvc.Window) (time.Duration, error)) (bool, error) {
+
+	unlock, locked, err := env.db.LockEntity(entity)
+	if err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, errEntityLocked
+	}
+	defer unlock()
+
+	cursor, found, err := env.db.GetCursor(entity)
+	if err != nil {
+		return false, err
+	}
+
+	dateFrom := ResumeDate(cursor, found)
+	fmt.Println("Resuming", entity, "from:", dateFrom)
+
+	cursor.RunID = env.runID
+	if !found {
+		cursor.HighWaterMark = dateFrom
+	}
+
+	window := time.Duration(tools.EnvironmentVariables.IncrementalWindow) * time.Second
+	if cursor.Window > 0 && cursor.Window < window {
+		window = cursor.Window
+		fmt.Println("Starting", entity, "with", window, "windows")
+	}
+
+	var lastWindow time.Duration
 
 	for time.Now().After(dateFrom) {
-		LogEntries := pagerdutysvc.GetPagerDutyLogEntries(dateFrom, dateTo)
-		MappedLogEntries := tools.GetMappedLogEntries(LogEntries)
 
-		for i := range MappedLogEntries {
-			env.db.UpdateLogEntries(MappedLogEntries[i])
+		if DeadlineReached(ctx, lastWindow) {
+			fmt.Println("Deadline approaching, stopping", entity, "transfer at:", dateFrom)
+			return false, nil
+		}
+
+		windowStarted := time.Now()
+		dateTo := dateFrom.Add(window)
+
+		settled, err := transfer(pagerdutysvc.NewWindow(dateFrom, dateTo))
+		if err != nil {
+			cursor.LastError = err.Error()
+			cursor.LastSuccessAt = time.Time{}
+			if saveErr := env.db.SetCursor(cursor); saveErr != nil {
+				fmt.Println("Failed to record", entity, "error:", saveErr)
+			}
+			return false, err
+		}
+
+		// The last window reaches into the future, only advance to what could have been fetched
+		cursor.HighWaterMark = dateTo
+		if cursor.HighWaterMark.After(windowStarted) {
+			cursor.HighWaterMark = windowStarted
+		}
+		cursor.LastSuccessAt = time.Now()
+		cursor.LastError = ""
+		cursor.Window = NextWindow(window, settled)
+		err = env.db.SetCursor(cursor)
+		if err != nil {
+			return false, err
 		}
 
+		lastWindow = time.Since(windowStarted)
 		dateFrom = dateTo
-		dateTo = dateFrom.Add(time.Duration(tools.EnvironmentVariables.IncrementalWindow) * time.Second)
+		window = cursor.Window
 	}
+
+	return true, nil
+}
+
+// DeadlineReached reports whether another window, expected to take about as long as the
+// last one, would run into DEADLINE_MARGIN before the context deadline
+func DeadlineReached(ctx context.Context, nextWindow time.Duration) bool {
+
+	if ctx.Err() != nil {
+		return true
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return false
+	}
+
+	margin := time.Duration(tools.EnvironmentVariables.DeadlineMargin) * time.Second
+
+	return time.Until(deadline) < margin+nextWindow
 }
 
 func main() {
 
 	// Retreive environment variables
 
-	tools.PopulateEnvVariables()
+	err := tools.PopulateEnvVariables()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Make the handler available for Remote Procedure Call by AWS Lambda
 	// Get variables for database connection
@@ -276,18 +1137,35 @@ func main() {
 		"password=%s dbname=%s sslmode=disable",
 		Host, User, Password, Dbname)
 
-	db := postgres.DatabaseConnect(ConnectionString)
+	db, err := postgres.DatabaseConnect(ConnectionString)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Bring the schema up to date before the first sync, concurrent cold starts wait on the migration lock
+	if tools.EnvironmentVariables.AutoMigrate {
+		applied, err := db.MigrateUp()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, migration := range applied {
+			fmt.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+		}
+	}
 
 	// Instantiate env struct with pointer to db connections, pass DB connection as parameter
-	env := &Env{db}
+	env := &Env{db: db, pd: pagerdutysvc.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)}
+
+	// Optionally keep long backfills going by re-invoking the function once it stops before the timeout
+	if tools.EnvironmentVariables.SelfInvoke {
+		var AWSSession tools.AWS
+		err = AWSSession.Open(os.Getenv("AWS_REGION"))
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	TransferEscalationPolicies(env)
-	TransferUsers(env)
-	TransferSchedules(env)
-	TransferServices(env)
-	TransferEscalationRules(env)
-	TransferLogEntries(env)
-	TransferIncidents(env)
+		env.invoker = &LambdaInvoker{FunctionName: lambdacontext.FunctionName, AWS: &AWSSession}
+	}
 
-	lambda.Start(HandleRequest)
+	lambda.Start(env.HandleRequest)
 }
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"../../pkg/pagerdutysvc"
+	"../../pkg/pagerdutysvc/pdfake"
+	"../../pkg/postgres"
+	"../../pkg/tools"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/PagerDuty/go-pagerduty"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestExtractEscalationRulesUser(t *testing.T) {
@@ -48,6 +56,301 @@ func TestExtractEscalationRulesSchedule(t *testing.T) {
 	assertEqual(t, result[0].ScheduleID, "TestScheduleID")
 }
 
+func TestSelectEntities(t *testing.T) {
+
+	var all, err = SelectEntities(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, len(transfers), len(all))
+
+	selected, err := SelectEntities([]string{"incidents", "log_entries"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, 2, len(selected))
+	assertEqual(t, true, selected["incidents"])
+	assertEqual(t, false, selected["users"])
+
+	_, err = SelectEntities([]string{"incidents", "grumble"})
+
+	if err == nil {
+		t.Error("Expected an error for an unknown entity")
+	}
+}
+
+func TestRunTransferRecoversPanic(t *testing.T) {
+
+	var result, err = RunTransfer(context.Background(), &Env{}, "users", complete(func(env *Env) error { panic("boom") }))
+
+	assertEqual(t, "users", result.Entity)
+	assertEqual(t, OutcomeFailed, result.Outcome)
+	assertEqual(t, "panic: boom", result.Error)
+
+	if err == nil {
+		t.Error("Expected the panic to be returned as an error")
+	}
+
+	result, _ = RunTransfer(context.Background(), &Env{}, "users", complete(func(env *Env) error { return nil }))
+
+	assertEqual(t, OutcomeSucceeded, result.Outcome)
+	assertEqual(t, 1, result.Attempts)
+	assertEqual(t, "", result.Error)
+
+	result, _ = RunTransfer(context.Background(), &Env{}, "incidents", func(ctx context.Context, env *Env) (bool, error) { return false, nil })
+
+	assertEqual(t, OutcomeIncomplete, result.Outcome)
+}
+
+func TestRunTransferEntityLocked(t *testing.T) {
+
+	var attempts int
+	var result, err = RunTransfer(context.Background(), &Env{}, "incidents", func(ctx context.Context, env *Env) (bool, error) {
+		attempts++
+		return false, errEntityLocked
+	})
+
+	assertEqual(t, OutcomeIncomplete, result.Outcome)
+	assertEqual(t, 1, attempts)
+	if !errors.Is(err, errEntityLocked) {
+		t.Errorf("Expected errEntityLocked, got %v", err)
+	}
+}
+
+func TestRunTransferCountsRows(t *testing.T) {
+
+	var env = &Env{}
+
+	var result, err = RunTransfer(context.Background(), env, "users", complete(func(env *Env) error {
+		for i := 0; i < 2; i++ {
+			err := env.countRow(postgres.Unchanged, &postgres.ConstraintError{Table: "users", Constraint: "users_pkey", Err: errors.New("duplicate")})
+			if err != nil {
+				return err
+			}
+		}
+		for _, outcome := range []postgres.UpsertOutcome{postgres.Inserted, postgres.Updated, postgres.Unchanged, postgres.Inserted} {
+			err := env.countRow(outcome, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, OutcomeSucceeded, result.Outcome)
+	assertEqual(t, 2, result.SkippedRows)
+	assertEqual(t, 2, result.Rows.Inserted)
+	assertEqual(t, 1, result.Rows.Updated)
+	assertEqual(t, 1, result.Rows.Unchanged)
+
+	result, _ = RunTransfer(context.Background(), env, "users", complete(func(env *Env) error {
+		return env.countRow(postgres.Unchanged, errors.New("syntax error"))
+	}))
+
+	assertEqual(t, OutcomeFailed, result.Outcome)
+	assertEqual(t, 0, result.SkippedRows)
+	assertEqual(t, 0, result.Rows.Inserted)
+}
+
+func TestRetryDelay(t *testing.T) {
+
+	var tests = []struct {
+		err      error
+		attempt  int
+		expected bool
+	}{
+		// The HTTP client already retried these
+		{&pagerdutysvc.RateLimitError{Op: "list incidents", Err: errors.New("429")}, 1, false},
+		{&pagerdutysvc.TransientError{Op: "list incidents", Err: errors.New("502")}, 1, false},
+		{fmt.Errorf("update incidents: %w", &postgres.TransientError{Op: "update incidents", Err: errors.New("deadlock")}), 2, true},
+		{&postgres.TransientError{Op: "update incidents", Err: errors.New("deadlock")}, maxTransferAttempts, false},
+		{&pagerdutysvc.APIError{Op: "list incidents", StatusCode: 400, Err: errors.New("400")}, 1, false},
+		{&tools.ConfigError{Variable: "PAGERDUTY_API_KEY", Err: errors.New("401")}, 1, false},
+		{&postgres.ConstraintError{Table: "incidents", Err: errors.New("duplicate")}, 1, false},
+	}
+
+	for _, test := range tests {
+		if _, retry := RetryDelay(test.err, test.attempt); retry != test.expected {
+			t.Errorf("RetryDelay(%v, %d) = %v, expected %v", test.err, test.attempt, retry, test.expected)
+		}
+	}
+}
+
+func TestDeadlineReached(t *testing.T) {
+
+	tools.EnvironmentVariables.DeadlineMargin = 30
+
+	assertEqual(t, false, DeadlineReached(context.Background(), time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	assertEqual(t, false, DeadlineReached(ctx, 0))
+	assertEqual(t, false, DeadlineReached(ctx, 20*time.Second))
+	assertEqual(t, true, DeadlineReached(ctx, 40*time.Second))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	assertEqual(t, true, DeadlineReached(ctx, 0))
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	assertEqual(t, true, DeadlineReached(cancelled, 0))
+}
+
+func TestResumeDate(t *testing.T) {
+
+	tools.EnvironmentVariables.PagerDutyEpoch = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tools.EnvironmentVariables.IncrementalBuffer = 3600
+
+	if got := ResumeDate(postgres.Cursor{}, false); !got.Equal(tools.EnvironmentVariables.PagerDutyEpoch) {
+		t.Errorf("Expected [%v], got [%v]", tools.EnvironmentVariables.PagerDutyEpoch, got)
+	}
+
+	cursor := postgres.Cursor{HighWaterMark: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)}
+	if got := ResumeDate(cursor, true); !got.Equal(time.Date(2018, 6, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected [%v], got [%v]", time.Date(2018, 6, 1, 11, 0, 0, 0, time.UTC), got)
+	}
+}
+
+func TestScheduleHorizon(t *testing.T) {
+
+	tools.EnvironmentVariables.ScheduleHorizon = 7 * 24 * 3600
+
+	horizon := ScheduleHorizon(time.Date(2018, 6, 10, 15, 30, 0, 0, time.UTC))
+
+	assertEqual(t, "2018-06-03T00:00:00Z", horizon.SinceParam())
+	assertEqual(t, "2018-06-18T00:00:00Z", horizon.UntilParam())
+}
+
+func TestEntriesAfter(t *testing.T) {
+
+	since := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	entries := []tools.ScheduleEntry{
+		{ID: "PSC0001:2018-03-01T00:00:00Z", Start: "2018-03-01T00:00:00Z"},
+		{ID: "PSC0001:2018-03-05T09:00:00Z", Start: "2018-03-05T09:00:00Z"},
+	}
+
+	after := EntriesAfter(entries, since)
+
+	assertEqual(t, 1, len(after))
+	assertEqual(t, "PSC0001:2018-03-05T09:00:00Z", after[0].ID)
+}
+
+func TestAlertsPending(t *testing.T) {
+
+	resolvedAt := time.Date(2018, 3, 1, 10, 42, 0, 0, time.UTC)
+	incidents := []tools.Incident{
+		{APIObject: pagerduty.APIObject{ID: "resolved"}, Status: "resolved", LastStatusChangeAt: "2018-03-01T10:42:00Z"},
+		{APIObject: pagerduty.APIObject{ID: "reresolved"}, Status: "resolved", LastStatusChangeAt: "2018-03-02T08:00:00Z"},
+		{APIObject: pagerduty.APIObject{ID: "open"}, Status: "triggered", LastStatusChangeAt: "2018-03-01T10:42:00Z"},
+		{APIObject: pagerduty.APIObject{ID: "new"}, Status: "resolved", LastStatusChangeAt: "2018-03-01T10:42:00Z"},
+	}
+	syncedAt := map[string]time.Time{"resolved": resolvedAt, "reresolved": resolvedAt, "open": resolvedAt}
+
+	pending := AlertsPending(incidents, syncedAt)
+
+	ids := []string{}
+	for i := range pending {
+		ids = append(ids, pending[i].APIObject.ID)
+	}
+	assertEqual(t, []string{"reresolved", "open", "new"}, ids)
+}
+
+func TestNotesPending(t *testing.T) {
+
+	tools.EnvironmentVariables.IncrementalBuffer = 3600
+
+	now := time.Date(2018, 3, 1, 11, 0, 0, 0, time.UTC)
+	resolvedAt := time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)
+	incidents := []tools.Incident{
+		{APIObject: pagerduty.APIObject{ID: "resolved"}, Status: "resolved", LastStatusChangeAt: "2018-03-01T09:00:00Z"},
+		{APIObject: pagerduty.APIObject{ID: "recent"}, Status: "resolved", LastStatusChangeAt: "2018-03-01T10:30:00Z"},
+		{APIObject: pagerduty.APIObject{ID: "open"}, Status: "acknowledged", LastStatusChangeAt: "2018-03-01T09:00:00Z"},
+		{APIObject: pagerduty.APIObject{ID: "new"}, Status: "resolved", LastStatusChangeAt: "2018-03-01T09:00:00Z"},
+	}
+	syncedAt := map[string]time.Time{"resolved": resolvedAt, "recent": time.Date(2018, 3, 1, 10, 30, 0, 0, time.UTC), "open": resolvedAt}
+
+	pending := NotesPending(incidents, syncedAt, nil, now)
+
+	ids := []string{}
+	for i := range pending {
+		ids = append(ids, pending[i].APIObject.ID)
+	}
+	assertEqual(t, []string{"recent", "open", "new"}, ids)
+
+	// A note added to a resolved incident long after it changed leaves the incident as it was
+	pending = NotesPending(incidents, syncedAt, map[string]bool{"resolved": true}, now)
+
+	ids = []string{}
+	for i := range pending {
+		ids = append(ids, pending[i].APIObject.ID)
+	}
+	assertEqual(t, []string{"resolved", "recent", "open", "new"}, ids)
+}
+
+func TestResyncPending(t *testing.T) {
+
+	fetched := map[string]bool{"PIN0001": true}
+
+	assertEqual(t, []string{"PIN0002", "PIN0003"}, ResyncPending([]string{"PIN0001", "PIN0002", "PIN0003", "PIN0002"}, fetched))
+	assertEqual(t, []string{}, ResyncPending(nil, fetched))
+}
+
+func TestNextWindow(t *testing.T) {
+
+	tools.EnvironmentVariables.IncrementalWindow = 24 * 3600
+	day := 24 * time.Hour
+
+	// Split windows shrink the next ones, windows that fit grow back up to INCREMENTAL_WINDOW
+	assertEqual(t, 3*time.Hour, NextWindow(day, 3*time.Hour))
+	assertEqual(t, 6*time.Hour, NextWindow(3*time.Hour, 3*time.Hour))
+	assertEqual(t, day, NextWindow(16*time.Hour, 16*time.Hour))
+	assertEqual(t, day, NextWindow(day, day))
+	assertEqual(t, day, NextWindow(0, 0))
+}
+
+func TestBatchFull(t *testing.T) {
+
+	tools.EnvironmentVariables.BatchSize = 1000
+
+	assertEqual(t, false, BatchFull(999))
+	assertEqual(t, true, BatchFull(1000))
+	assertEqual(t, true, BatchFull(1100))
+}
+
+func TestEscalationPoliciesFetchedOnce(t *testing.T) {
+
+	tools.EnvironmentVariables.PaginationLimit = 25
+	fake := pdfake.Default()
+	env := &Env{pd: fake}
+
+	for i := 0; i < 2; i++ {
+		ids, err := env.escalationPolicyIDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(fake.EscalationPolicies), len(ids))
+	}
+	assertEqual(t, 1, fake.Calls("ListEscalationPolicies"))
+
+	// The next invocation fetches them again
+	env.policyIDs = nil
+	_, err := env.escalationPolicyIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 2, fake.Calls("ListEscalationPolicies"))
+}
+
 func assertEqual(t *testing.T, e, g interface{}) (r bool) {
 	r = compare(e, g)
 	if !r {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"../../pkg/postgres"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `Usage: migrate [-database DSN] [-steps N] up|down|status
+
+  up      apply every pending migration
+  down    revert the last -steps applied migrations (default 1)
+  status  list migrations and whether they are applied
+
+The connection string defaults to the DATABASE_DSN environment variable.
+`
+
+func main() {
+
+	database := flag.String("database", os.Getenv("DATABASE_DSN"), "Postgres connection string")
+	steps := flag.Int("steps", 1, "number of migrations to revert with down")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	if flag.NArg() != 1 || *database == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := postgres.DatabaseConnect(*database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch flag.Arg(0) {
+	case "up":
+		applied, err := db.MigrateUp()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+
+	case "down":
+		reverted, err := db.MigrateDown(*steps)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			log.Fatal(err)
+		}
+		applied := false
+		for _, migration := range status {
+			applied = applied || migration.Applied
+		}
+		if !applied {
+			fmt.Println("No migrations applied")
+		}
+
+		for _, migration := range status {
+			switch {
+			case migration.Unknown:
+				fmt.Printf("%04d_%s\tapplied %s, unknown to this binary\n", migration.Version, migration.Name, migration.AppliedAt.Format("2006-01-02 15:04:05"))
+			case migration.Applied:
+				fmt.Printf("%04d_%s\tapplied %s\n", migration.Version, migration.Name, migration.AppliedAt.Format("2006-01-02 15:04:05"))
+			default:
+				fmt.Printf("%04d_%s\tpending\n", migration.Version, migration.Name)
+			}
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,96 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// APIError is a PagerDuty API call that failed permanently, retrying it won't help
+type APIError struct {
+	Op         string
+	StatusCode int
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pagerduty %s: %v", e.Op, e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// TransientError is a PagerDuty API call that failed with a 5xx response or a network error
+type TransientError struct {
+	Op         string
+	StatusCode int
+	Err        error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("pagerduty %s: transient error: %v", e.Op, e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitError is a PagerDuty API call rejected with HTTP 429
+type RateLimitError struct {
+	Op  string
+	Err error
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("pagerduty %s: rate limited: %v", e.Op, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// go-pagerduty only reports the HTTP status code, or the failed HTTP round trip, inside the error message
+var (
+	statusCodePattern   = regexp.MustCompile(`HTTP response code: (\d+)`)
+	networkErrorPattern = regexp.MustCompile(`Error calling the API endpoint`)
+)
+
+// wrapError classifies an error returned by go-pagerduty into one of the typed errors above
+func wrapError(op string, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	statusCode := 0
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		statusCode, _ = strconv.Atoi(match[1])
+	}
+
+	switch {
+	case statusCode == 429:
+		return &RateLimitError{Op: op, Err: err}
+	// A 403 is a valid key without access to one endpoint, e.g. an add-on that isn't enabled, and only
+	// fails that entity. A 401 fails them all.
+	case statusCode == 401:
+		return &tools.ConfigError{Variable: "PAGERDUTY_API_KEY", Err: &APIError{Op: op, StatusCode: statusCode, Err: err}}
+	case statusCode >= 500:
+		return &TransientError{Op: op, StatusCode: statusCode, Err: err}
+	case statusCode == 0 && isNetworkError(err):
+		return &TransientError{Op: op, Err: err}
+	default:
+		return &APIError{Op: op, StatusCode: statusCode, Err: err}
+	}
+}
+
+func isNetworkError(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return networkErrorPattern.MatchString(err.Error())
+}
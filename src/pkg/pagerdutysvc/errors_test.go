@@ -0,0 +1,62 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWrapError(t *testing.T) {
+
+	var tests = []struct {
+		input    error
+		expected string
+	}{
+		{errors.New("Failed call API endpoint. HTTP response code: 429. Error: &{2020 Rate Limit Exceeded []}"), "rate limit"},
+		{errors.New("Failed call API endpoint. HTTP response code: 502. Error: &{0 Bad Gateway []}"), "transient"},
+		{errors.New("Error calling the API endpoint: dial tcp: i/o timeout"), "transient"},
+		{errors.New("Failed call API endpoint. HTTP response code: 401. Error: &{2006 Unauthorized []}"), "config"},
+		{errors.New("Failed call API endpoint. HTTP response code: 403. Error: &{2010 Access Denied []}"), "api"},
+		{errors.New("Failed call API endpoint. HTTP response code: 400. Error: &{2001 Invalid Input Provided []}"), "api"},
+	}
+
+	for _, test := range tests {
+
+		var rateLimitErr *RateLimitError
+		var transientErr *TransientError
+		var configErr *tools.ConfigError
+		var apiErr *APIError
+
+		var output string
+		err := wrapError("list incidents", test.input)
+
+		switch {
+		case errors.As(err, &rateLimitErr):
+			output = "rate limit"
+		case errors.As(err, &transientErr):
+			output = "transient"
+		case errors.As(err, &configErr):
+			output = "config"
+		case errors.As(err, &apiErr):
+			output = "api"
+		}
+
+		if output != test.expected {
+			t.Errorf("Test Failed: %v inputted, %s expected, received: %s", test.input, test.expected, output)
+		}
+
+		if !errors.Is(err, test.input) {
+			t.Errorf("Expected %v to wrap %v", err, test.input)
+		}
+	}
+
+	if wrapError("list incidents", nil) != nil {
+		t.Error("Expected nil error to stay nil")
+	}
+
+	var apiErr *APIError
+	if !errors.As(wrapError("list users", fmt.Errorf("unexpected")), &apiErr) {
+		t.Error("Expected unknown errors to be reported as APIError")
+	}
+}
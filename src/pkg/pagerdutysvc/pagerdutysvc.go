@@ -7,9 +7,9 @@ import (
 	"time"
 )
 
-func GetPagerDutyEscalationPolicies() []pagerduty.EscalationPolicy {
+// EachEscalationPolicyPage calls fn with every page of escalation policies as soon as it's fetched
+func EachEscalationPolicyPage(src Source, fn func(page []pagerduty.EscalationPolicy) error) error {
 
-	var EscalationPolicies []pagerduty.EscalationPolicy
 	var APIList pagerduty.APIListObject
 
 	// Override default pagination limit
@@ -17,17 +17,17 @@ func GetPagerDutyEscalationPolicies() []pagerduty.EscalationPolicy {
 
 	opts := pagerduty.ListEscalationPoliciesOptions{APIListObject: APIList}
 
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
-
 	for {
 
-		eps, err := client.ListEscalationPolicies(opts)
-
+		eps, err := src.ListEscalationPolicies(opts)
 		if err != nil {
-			panic(err)
+			return wrapError("list escalation policies", err)
 		}
 
-		EscalationPolicies = append(EscalationPolicies, eps.EscalationPolicies...)
+		err = fn(eps.EscalationPolicies)
+		if err != nil {
+			return err
+		}
 		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
 		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 		opts = pagerduty.ListEscalationPoliciesOptions{APIListObject: APIList}
@@ -35,70 +35,87 @@ func GetPagerDutyEscalationPolicies() []pagerduty.EscalationPolicy {
 		if eps.APIListObject.More != true {
 			fmt.Println("Escalation Policies Extracted")
 
-			return EscalationPolicies
+			return nil
 
 		}
 
 	}
-	return EscalationPolicies
-
 }
 
-func GetPagerDutyEscalationRule(escID string) []pagerduty.EscalationRule {
+func GetPagerDutyEscalationRule(src Source, escID string) ([]pagerduty.EscalationRule, error) {
 
 	var EscalationRules []pagerduty.EscalationRule
 
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
+	ers, err := src.ListEscalationRules(escID)
+	if err != nil {
+		return nil, wrapError("list escalation rules for "+escID, err)
+	}
 
-	ers, err := client.ListEscalationRules(escID)
+	EscalationRules = ers.EscalationRules
+
+	return EscalationRules, nil
+}
 
+// GetPagerDutyEscalationRules fetches the rules of every policy in ids concurrently, rules[i] are
+// those of ids[i]
+func GetPagerDutyEscalationRules(src Source, ids []string) ([][]pagerduty.EscalationRule, error) {
+
+	EscalationRules := make([][]pagerduty.EscalationRule, len(ids))
+
+	err := ForEach(len(ids), func(i int) error {
+		rules, err := GetPagerDutyEscalationRule(src, ids[i])
+		EscalationRules[i] = rules
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	EscalationRules = ers.EscalationRules
+	fmt.Println("Escalation Rules Extracted")
 
-	return EscalationRules
+	return EscalationRules, nil
 }
 
-func GetPagerDutyUsers() []pagerduty.User {
+// EachUserPage calls fn with every page of users, with their contact methods and notification rules as soon as it's fetched
+func EachUserPage(src Source, fn func(page []tools.PagerDutyUser) error) error {
 
-	var Users []pagerduty.User
 	var APIList pagerduty.APIListObject
 
 	// Override default pagination limit
 	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 
-	opts := pagerduty.ListUsersOptions{APIListObject: APIList}
-
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
+	// Contact methods and notification rules come expanded with each user
+	includes := []string{"contact_methods", "notification_rules"}
+	opts := pagerduty.ListUsersOptions{APIListObject: APIList, Includes: includes}
 
 	for {
 
-		usr, err := client.ListUsers(opts)
+		usr, err := src.ListUsers(opts)
 		if err != nil {
-			panic(err)
+			return wrapError("list users", err)
 		}
 
-		Users = append(Users, usr.Users...)
+		err = fn(usr.Users)
+		if err != nil {
+			return err
+		}
 		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
 		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
-		opts = pagerduty.ListUsersOptions{APIListObject: APIList}
+		opts = pagerduty.ListUsersOptions{APIListObject: APIList, Includes: includes}
 
 		if usr.APIListObject.More != true {
 			fmt.Println("Users Extracted")
 
-			return Users
+			return nil
 
 		}
 
 	}
-	return Users
 }
 
-func GetPagerDutySchedules() []pagerduty.Schedule {
+// EachSchedulePage calls fn with every page of schedules as soon as it's fetched
+func EachSchedulePage(src Source, fn func(page []pagerduty.Schedule) error) error {
 
-	var Schedules []pagerduty.Schedule
 	var APIList pagerduty.APIListObject
 
 	// Override default pagination limit
@@ -106,16 +123,17 @@ func GetPagerDutySchedules() []pagerduty.Schedule {
 
 	opts := pagerduty.ListSchedulesOptions{APIListObject: APIList}
 
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
-
 	for {
 
-		sch, err := client.ListSchedules(opts)
+		sch, err := src.ListSchedules(opts)
 		if err != nil {
-			panic(err)
+			return wrapError("list schedules", err)
 		}
 
-		Schedules = append(Schedules, sch.Schedules...)
+		err = fn(sch.Schedules)
+		if err != nil {
+			return err
+		}
 		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
 		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 		opts = pagerduty.ListSchedulesOptions{APIListObject: APIList}
@@ -123,149 +141,441 @@ func GetPagerDutySchedules() []pagerduty.Schedule {
 		if sch.APIListObject.More != true {
 			fmt.Println("Schedules Extracted")
 
-			return Schedules
+			return nil
+
+		}
+
+	}
+}
+
+// GetPagerDutySchedule renders a schedule's layers, overrides and final schedule over window
+func GetPagerDutySchedule(src Source, scheduleID string, window Window) (*pagerduty.Schedule, error) {
+
+	opts := pagerduty.GetScheduleOptions{Since: window.SinceParam(), Until: window.UntilParam(), TimeZone: "UTC"}
+
+	sch, err := src.GetSchedule(scheduleID, opts)
+	if err != nil {
+		return nil, wrapError("get schedule "+scheduleID, err)
+	}
+
+	return sch, nil
+}
+
+// GetPagerDutyOverrides returns the overrides of a schedule overlapping window, in full
+func GetPagerDutyOverrides(src Source, scheduleID string, window Window) ([]pagerduty.Override, error) {
+
+	opts := pagerduty.ListOverridesOptions{Since: window.SinceParam(), Until: window.UntilParam()}
+
+	overrides, err := src.ListOverrides(scheduleID, opts)
+	if err != nil {
+		return nil, wrapError("list overrides "+scheduleID, err)
+	}
+
+	return overrides, nil
+}
+
+func GetPagerDutyPriorities(src Source) ([]tools.PagerDutyPriority, error) {
+
+	var Priorities []tools.PagerDutyPriority
+	var APIList pagerduty.APIListObject
+
+	// Override default pagination limit
+	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+	for {
+
+		prs, err := src.ListPriorities(APIList)
+		if err != nil {
+			return nil, wrapError("list priorities", err)
+		}
+
+		Priorities = append(Priorities, prs.Priorities...)
+		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
+		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+		if prs.APIListObject.More != true {
+			fmt.Println("Priorities Extracted")
+
+			return Priorities, nil
 
 		}
 
 	}
-	return Schedules
 }
 
-func GetPagerDutyServices() []pagerduty.Service {
+// EachTeamPage calls fn with every page of teams as soon as it's fetched
+func EachTeamPage(src Source, fn func(page []pagerduty.Team) error) error {
 
-	var Services []pagerduty.Service
 	var APIList pagerduty.APIListObject
 
 	// Override default pagination limit
 	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 
-	opts := pagerduty.ListServiceOptions{APIListObject: APIList}
+	opts := pagerduty.ListTeamOptions{APIListObject: APIList}
+
+	for {
+
+		tms, err := src.ListTeams(opts)
+		if err != nil {
+			return wrapError("list teams", err)
+		}
+
+		err = fn(tms.Teams)
+		if err != nil {
+			return err
+		}
+		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
+		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+		opts = pagerduty.ListTeamOptions{APIListObject: APIList}
+
+		if tms.APIListObject.More != true {
+			fmt.Println("Teams Extracted")
+
+			return nil
+
+		}
+
+	}
+}
 
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
+func GetPagerDutyTeamMembers(src Source, teamID string) ([]tools.PagerDutyTeamMember, error) {
+
+	var Members []tools.PagerDutyTeamMember
+	var APIList pagerduty.APIListObject
+
+	// Override default pagination limit
+	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 
 	for {
 
-		ser, err := client.ListServices(opts)
+		mbr, err := src.ListTeamMembers(teamID, APIList)
 		if err != nil {
-			panic(err)
+			return nil, wrapError("list members of team "+teamID, err)
 		}
 
-		Services = append(Services, ser.Services...)
+		Members = append(Members, mbr.Members...)
 		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
 		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
-		opts = pagerduty.ListServiceOptions{APIListObject: APIList}
+
+		if mbr.APIListObject.More != true {
+			return Members, nil
+		}
+
+	}
+}
+
+// EachServicePage calls fn with every page of technical services, with their integrations as soon as it's fetched
+func EachServicePage(src Source, fn func(page []pagerduty.Service) error) error {
+
+	var APIList pagerduty.APIListObject
+
+	// Override default pagination limit
+	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+	// Integrations come expanded with their vendor
+	includes := []string{"integrations"}
+	opts := pagerduty.ListServiceOptions{APIListObject: APIList, Includes: includes}
+
+	for {
+
+		ser, err := src.ListServices(opts)
+		if err != nil {
+			return wrapError("list services", err)
+		}
+
+		err = fn(ser.Services)
+		if err != nil {
+			return err
+		}
+		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
+		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+		opts = pagerduty.ListServiceOptions{APIListObject: APIList, Includes: includes}
 
 		if ser.APIListObject.More != true {
 			fmt.Println("Services Extracted")
 
-			return Services
+			return nil
 
 		}
 
 	}
-	return Services
 }
 
-func GetPagerDutyIncidents(dateFrom time.Time, dateTo time.Time) []pagerduty.Incident {
+// EachBusinessServicePage calls fn with every page of business services as soon as it's fetched
+func EachBusinessServicePage(src Source, fn func(page []tools.PagerDutyBusinessService) error) error {
+
+	var APIList pagerduty.APIListObject
 
-	fmt.Println("Working with:", dateFrom.String(), dateTo.String())
+	// Override default pagination limit
+	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+	for {
+
+		bus, err := src.ListBusinessServices(APIList)
+		if err != nil {
+			return wrapError("list business services", err)
+		}
+
+		err = fn(bus.BusinessServices)
+		if err != nil {
+			return err
+		}
+		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
+		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+		if bus.APIListObject.More != true {
+			fmt.Println("Business Services Extracted")
+
+			return nil
+
+		}
 
-	opts := pagerduty.ListIncidentsOptions{
-		Since: dateFrom.String(),
-		Until: dateTo.String(),
 	}
+}
+
+// GetPagerDutyServiceDependencies fetches the relationships of every given service of a kind, a
+// relationship between two of them is returned for both
+func GetPagerDutyServiceDependencies(src Source, kind string, ids []string) ([]tools.PagerDutyServiceDependency, error) {
+
+	var Dependencies []tools.PagerDutyServiceDependency
+	responses := make([]*ListServiceDependenciesResponse, len(ids))
+
+	err := ForEach(len(ids), func(i int) error {
+		dep, err := src.ListServiceDependencies(kind, ids[i])
+		if err != nil {
+			return wrapError("list service dependencies", err)
+		}
+		responses[i] = dep
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dep := range responses {
+		Dependencies = append(Dependencies, dep.Relationships...)
+	}
+
+	fmt.Println("Service Dependencies Extracted")
+
+	return Dependencies, nil
+}
+
+// EachIncidentPage calls fn with every page of incidents created in window as soon as it's fetched,
+// so a window never has to fit in memory. A window holding more incidents than pagination reaches is
+// split from its first page's total, before fn gets any of it. The duration of the smallest
+// sub-window is returned.
+func EachIncidentPage(src Source, window Window, fn func(page []pagerduty.Incident) error) (time.Duration, error) {
+
+	size, err := splitWindow(window, func(window Window) error {
+		return eachIncidentPage(src, window, fn)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Println("Incidents Extracted")
+
+	return size, nil
+}
+
+func eachIncidentPage(src Source, window Window, fn func(page []pagerduty.Incident) error) error {
+
+	fmt.Println("Working with:", window)
 
-	var Incidents []pagerduty.Incident
 	var APIList pagerduty.APIListObject
 
 	// Override default pagination limit
 	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
+	opts := pagerduty.ListIncidentsOptions{APIListObject: APIList, Since: window.SinceParam(),
+		Until: window.UntilParam(), TimeZone: "UTC"}
 
 	for {
 
-		inc, err := client.ListIncidents(opts)
+		inc, err := src.ListIncidents(opts)
 		if err != nil {
-			panic(err)
+			return wrapError("list incidents", err)
 		}
 
-		// fmt.Printf("%+v", inc)
+		// The first page tells how many incidents the window holds, one that pagination can't get
+		// through is split before any of them is handed to fn
+		if APIList.Offset == 0 && inc.APIListObject.Total > MaxOffset {
+			return errWindowTooLarge
+		}
 
-		Incidents = append(Incidents, inc.Incidents...)
+		err = fn(inc.Incidents)
+		if err != nil {
+			return err
+		}
 		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
 		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
-		opts = pagerduty.ListIncidentsOptions{APIListObject: APIList, Since: dateFrom.String(),
-			Until: dateTo.String()}
+		opts = pagerduty.ListIncidentsOptions{APIListObject: APIList, Since: window.SinceParam(),
+			Until: window.UntilParam(), TimeZone: "UTC"}
 
 		if inc.APIListObject.More != true {
-			fmt.Println("Incidents Extracted")
-
-			return Incidents
+			return nil
+		}
 
+		// Only reached when the total wasn't reported or the window grew since its first page
+		if pastMaxOffset(APIList.Offset, APIList.Limit) {
+			return errWindowTooLarge
 		}
 	}
+}
 
-	inc, err := client.ListIncidents(opts)
+// GetPagerDutyIncidentsByID fetches the current state of each incident in ids, concurrently
+func GetPagerDutyIncidentsByID(src Source, ids []string) ([]pagerduty.Incident, error) {
+
+	Incidents := make([]pagerduty.Incident, len(ids))
+
+	err := ForEach(len(ids), func(i int) error {
+		inc, err := src.GetIncident(ids[i])
+		if err != nil {
+			return wrapError("get incident", err)
+		}
+		Incidents[i] = *inc
+		return nil
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// fmt.Printf("%+v", inc)
+	return Incidents, nil
+}
+
+// GetPagerDutyIncidentNotes returns every note of an incident, the endpoint isn't paginated
+func GetPagerDutyIncidentNotes(src Source, incidentID string) ([]pagerduty.IncidentNote, error) {
 
-	Incidents = append(Incidents, inc.Incidents...)
+	notes, err := src.ListIncidentNotes(incidentID)
+	if err != nil {
+		return nil, wrapError("list notes of incident "+incidentID, err)
+	}
 
-	return Incidents
+	return notes, nil
 }
 
-func GetPagerDutyLogEntries(dateFrom time.Time, dateTo time.Time) []pagerduty.LogEntry {
+func GetPagerDutyIncidentAlerts(src Source, incidentID string) ([]tools.PagerDutyAlert, error) {
 
-	fmt.Println("Working with:", dateFrom.String(), dateTo.String())
+	var Alerts []tools.PagerDutyAlert
+	var APIList pagerduty.APIListObject
+
+	// Override default pagination limit
+	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+	for {
+
+		alr, err := src.ListIncidentAlerts(incidentID, APIList)
+		if err != nil {
+			return nil, wrapError("list alerts of incident "+incidentID, err)
+		}
+
+		Alerts = append(Alerts, alr.Alerts...)
+		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
+		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+		if alr.APIListObject.More != true {
+			return Alerts, nil
+		}
 
-	opts := pagerduty.ListLogEntriesOptions{
-		Since:    dateFrom.String(),
-		Until:    dateTo.String(),
-		TimeZone: "UTC",
 	}
+}
+
+// EachLogEntryPage calls fn with every page of log entries created in window as soon as it's fetched.
+// Windows are split like EachIncidentPage's.
+func EachLogEntryPage(src Source, window Window, fn func(page []tools.PagerDutyLogEntry) error) (time.Duration, error) {
+
+	size, err := splitWindow(window, func(window Window) error {
+		return eachLogEntryPage(src, window, fn)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Println("Log Entries Extracted")
+
+	return size, nil
+}
+
+func eachLogEntryPage(src Source, window Window, fn func(page []tools.PagerDutyLogEntry) error) error {
+
+	fmt.Println("Working with:", window)
 
-	var LogEntries []pagerduty.LogEntry
 	var APIList pagerduty.APIListObject
 
 	// Override default pagination limit
 	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
 
-	client := pagerduty.NewClient(tools.EnvironmentVariables.PagerDutyApiKey)
+	opts := pagerduty.ListLogEntriesOptions{APIListObject: APIList, Since: window.SinceParam(),
+		Until: window.UntilParam(), TimeZone: "UTC"}
 
 	for {
 
-		log, err := client.ListLogEntries(opts)
+		log, err := src.ListLogEntries(opts)
 		if err != nil {
-			panic(err)
+			return wrapError("list log entries", err)
 		}
 
-		// fmt.Printf("%+v", log)
+		// The first page tells how many log entries the window holds, one that pagination can't get
+		// through is split before any of them is handed to fn
+		if APIList.Offset == 0 && log.APIListObject.Total > MaxOffset {
+			return errWindowTooLarge
+		}
 
-		LogEntries = append(LogEntries, log.LogEntries...)
+		err = fn(log.LogEntries)
+		if err != nil {
+			return err
+		}
 		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
 		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
-		opts = pagerduty.ListLogEntriesOptions{APIListObject: APIList, Since: dateFrom.String(),
-			Until: dateTo.String(), TimeZone: "UTC"}
+		opts = pagerduty.ListLogEntriesOptions{APIListObject: APIList, Since: window.SinceParam(),
+			Until: window.UntilParam(), TimeZone: "UTC"}
 
 		if log.APIListObject.More != true {
-			fmt.Println("Log Entries Extracted")
-
-			return LogEntries
+			return nil
+		}
 
+		// Only reached when the total wasn't reported or the window grew since its first page
+		if pastMaxOffset(APIList.Offset, APIList.Limit) {
+			return errWindowTooLarge
 		}
 	}
+}
 
-	log, err := client.ListLogEntries(opts)
-	if err != nil {
-		panic(err)
-	}
+// EachOnCallPage calls fn with every page of on-call shifts overlapping window as soon as it's fetched
+func EachOnCallPage(src Source, window Window, fn func(page []pagerduty.OnCall) error) error {
 
-	LogEntries = append(LogEntries, log.LogEntries...)
+	fmt.Println("Working with:", window)
 
-	return LogEntries
+	var APIList pagerduty.APIListObject
+
+	// Override default pagination limit
+	APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+
+	opts := pagerduty.ListOnCallOptions{APIListObject: APIList, Since: window.SinceParam(),
+		Until: window.UntilParam(), TimeZone: "UTC"}
+
+	for {
 
+		onc, err := src.ListOnCalls(opts)
+		if err != nil {
+			return wrapError("list on-calls", err)
+		}
+
+		err = fn(onc.OnCalls)
+		if err != nil {
+			return err
+		}
+		APIList.Offset += tools.EnvironmentVariables.PaginationLimit
+		APIList.Limit = tools.EnvironmentVariables.PaginationLimit
+		opts = pagerduty.ListOnCallOptions{APIListObject: APIList, Since: window.SinceParam(),
+			Until: window.UntilParam(), TimeZone: "UTC"}
+
+		if onc.APIListObject.More != true {
+			fmt.Println("On-Calls Extracted")
+
+			return nil
+
+		}
+	}
 }
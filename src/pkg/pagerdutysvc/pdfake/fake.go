@@ -0,0 +1,516 @@
+// Package pdfake is an in-memory pagerdutysvc.Source for tests, seeded from JSON fixtures shaped
+// like the PagerDuty API list responses.
+package pdfake
+
+import (
+	".."
+	"../../tools"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/PagerDuty/go-pagerduty"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// Fake serves its fields the way the API would: list calls are paged with Offset and Limit,
+// incidents and log entries are filtered by Since and Until, on-call shifts and overrides are
+// returned when they overlap Since and Until, a schedule's final schedule is cut to Since and
+// Until, service dependencies are returned for both of their services, and escalation rules are
+// taken from the escalation policies. Calls only read the fields, so it can serve concurrent
+// transfers.
+type Fake struct {
+	EscalationPolicies []pagerduty.EscalationPolicy     `json:"escalation_policies"`
+	Users              []tools.PagerDutyUser            `json:"users"`
+	Schedules          []pagerduty.Schedule             `json:"schedules"`
+	Services           []pagerduty.Service              `json:"services"`
+	Incidents          []pagerduty.Incident             `json:"incidents"`
+	LogEntries         []tools.PagerDutyLogEntry        `json:"log_entries"`
+	OnCalls            []pagerduty.OnCall               `json:"oncalls"`
+	Teams              []pagerduty.Team                 `json:"teams"`
+	Priorities         []tools.PagerDutyPriority        `json:"priorities"`
+	BusinessServices   []tools.PagerDutyBusinessService `json:"business_services"`
+
+	// ServiceDependencies are returned for the services on either of their sides
+	ServiceDependencies []tools.PagerDutyServiceDependency `json:"service_dependencies"`
+
+	// Alerts, Notes and TeamMembers are keyed by incident and team ID
+	Alerts      map[string][]tools.PagerDutyAlert      `json:"alerts"`
+	Notes       map[string][]pagerduty.IncidentNote    `json:"notes"`
+	TeamMembers map[string][]tools.PagerDutyTeamMember `json:"team_members"`
+
+	// Overrides are keyed by schedule ID
+	Overrides map[string][]pagerduty.Override `json:"overrides"`
+
+	// Errors makes a method fail with the given error, keyed by method name e.g. "ListIncidents"
+	Errors map[string]error `json:"-"`
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// Default returns a Fake seeded with the bundled fixtures/default.json
+func Default() *Fake {
+
+	file, err := fixtures.Open("fixtures/default.json")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	fake, err := Load(file)
+	if err != nil {
+		panic(err)
+	}
+
+	return fake
+}
+
+// LoadFile returns a Fake seeded from a fixture file
+func LoadFile(path string) (*Fake, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Load(file)
+}
+
+// Load returns a Fake seeded from JSON fixtures
+func Load(r io.Reader) (*Fake, error) {
+
+	fake := &Fake{}
+
+	err := json.NewDecoder(r).Decode(fake)
+	if err != nil {
+		return nil, fmt.Errorf("decode fixtures: %w", err)
+	}
+
+	return fake, nil
+}
+
+// Calls returns how many times method has been called
+func (f *Fake) Calls(method string) int {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[method]
+}
+
+// call records a call to method and returns the error configured for it
+func (f *Fake) call(method string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[method]++
+
+	return f.Errors[method]
+}
+
+func (f *Fake) ListEscalationPolicies(o pagerduty.ListEscalationPoliciesOptions) (*pagerduty.ListEscalationPoliciesResponse, error) {
+
+	if err := f.call("ListEscalationPolicies"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.EscalationPolicies), o.APIListObject)
+
+	return &pagerduty.ListEscalationPoliciesResponse{APIListObject: list, EscalationPolicies: f.EscalationPolicies[start:end]}, nil
+}
+
+func (f *Fake) ListEscalationRules(escID string) (*pagerduty.ListEscalationRulesResponse, error) {
+
+	if err := f.call("ListEscalationRules"); err != nil {
+		return nil, err
+	}
+
+	for _, policy := range f.EscalationPolicies {
+		if policy.ID == escID {
+			return &pagerduty.ListEscalationRulesResponse{EscalationRules: policy.EscalationRules}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: escalation policy %s not found", escID)
+}
+
+func (f *Fake) ListUsers(o pagerduty.ListUsersOptions) (*pagerdutysvc.ListUsersResponse, error) {
+
+	if err := f.call("ListUsers"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.Users), o.APIListObject)
+
+	return &pagerdutysvc.ListUsersResponse{APIListObject: list, Users: f.Users[start:end]}, nil
+}
+
+func (f *Fake) ListSchedules(o pagerduty.ListSchedulesOptions) (*pagerduty.ListSchedulesResponse, error) {
+
+	if err := f.call("ListSchedules"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.Schedules), o.APIListObject)
+
+	return &pagerduty.ListSchedulesResponse{APIListObject: list, Schedules: f.Schedules[start:end]}, nil
+}
+
+func (f *Fake) GetSchedule(id string, o pagerduty.GetScheduleOptions) (*pagerduty.Schedule, error) {
+
+	if err := f.call("GetSchedule"); err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range f.Schedules {
+		if schedule.ID != id {
+			continue
+		}
+
+		var entries []pagerduty.RenderedScheduleEntry
+		for _, entry := range schedule.FinalSchedule.RenderedScheduleEntries {
+			ok, err := overlaps(entry.Start, entry.End, o.Since, o.Until)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			// The API renders the final schedule between Since and Until only
+			if o.Since != "" {
+				if before, _ := inRange(entry.Start, "", o.Since); before {
+					entry.Start = o.Since
+				}
+			}
+			if o.Until != "" {
+				if before, _ := inRange(entry.End, "", o.Until); !before {
+					entry.End = o.Until
+				}
+			}
+			entries = append(entries, entry)
+		}
+		schedule.FinalSchedule.RenderedScheduleEntries = entries
+
+		return &schedule, nil
+	}
+
+	return nil, fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: schedule %s not found", id)
+}
+
+func (f *Fake) ListOverrides(id string, o pagerduty.ListOverridesOptions) ([]pagerduty.Override, error) {
+
+	if err := f.call("ListOverrides"); err != nil {
+		return nil, err
+	}
+
+	var overrides []pagerduty.Override
+	for _, override := range f.Overrides[id] {
+		ok, err := overlaps(override.Start, override.End, o.Since, o.Until)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			overrides = append(overrides, override)
+		}
+	}
+
+	return overrides, nil
+}
+
+func (f *Fake) ListServices(o pagerduty.ListServiceOptions) (*pagerduty.ListServiceResponse, error) {
+
+	if err := f.call("ListServices"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.Services), o.APIListObject)
+
+	return &pagerduty.ListServiceResponse{APIListObject: list, Services: f.Services[start:end]}, nil
+}
+
+func (f *Fake) ListBusinessServices(o pagerduty.APIListObject) (*pagerdutysvc.ListBusinessServicesResponse, error) {
+
+	if err := f.call("ListBusinessServices"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.BusinessServices), o)
+
+	return &pagerdutysvc.ListBusinessServicesResponse{APIListObject: list, BusinessServices: f.BusinessServices[start:end]}, nil
+}
+
+func (f *Fake) ListServiceDependencies(kind string, id string) (*pagerdutysvc.ListServiceDependenciesResponse, error) {
+
+	if err := f.call("ListServiceDependencies"); err != nil {
+		return nil, err
+	}
+
+	response := &pagerdutysvc.ListServiceDependenciesResponse{Relationships: []tools.PagerDutyServiceDependency{}}
+	for _, dependency := range f.ServiceDependencies {
+		if dependency.SupportingService.ID == id || dependency.DependentService.ID == id {
+			response.Relationships = append(response.Relationships, dependency)
+		}
+	}
+
+	return response, nil
+}
+
+func (f *Fake) ListPriorities(o pagerduty.APIListObject) (*pagerdutysvc.ListPrioritiesResponse, error) {
+
+	if err := f.call("ListPriorities"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.Priorities), o)
+
+	return &pagerdutysvc.ListPrioritiesResponse{APIListObject: list, Priorities: f.Priorities[start:end]}, nil
+}
+
+func (f *Fake) ListTeams(o pagerduty.ListTeamOptions) (*pagerduty.ListTeamResponse, error) {
+
+	if err := f.call("ListTeams"); err != nil {
+		return nil, err
+	}
+
+	start, end, list := page(len(f.Teams), o.APIListObject)
+
+	return &pagerduty.ListTeamResponse{APIListObject: list, Teams: f.Teams[start:end]}, nil
+}
+
+func (f *Fake) ListTeamMembers(teamID string, o pagerduty.APIListObject) (*pagerdutysvc.ListTeamMembersResponse, error) {
+
+	if err := f.call("ListTeamMembers"); err != nil {
+		return nil, err
+	}
+
+	for _, team := range f.Teams {
+		if team.ID == teamID {
+			members := f.TeamMembers[teamID]
+			start, end, list := page(len(members), o)
+
+			return &pagerdutysvc.ListTeamMembersResponse{APIListObject: list, Members: members[start:end]}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: team %s not found", teamID)
+}
+
+func (f *Fake) ListIncidents(o pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error) {
+
+	if err := f.call("ListIncidents"); err != nil {
+		return nil, err
+	}
+
+	var incidents []pagerduty.Incident
+	for _, incident := range f.Incidents {
+		ok, err := inRange(incident.CreatedAt, o.Since, o.Until)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			incidents = append(incidents, incident)
+		}
+	}
+
+	start, end, list := page(len(incidents), o.APIListObject)
+
+	return &pagerduty.ListIncidentsResponse{APIListObject: list, Incidents: incidents[start:end]}, nil
+}
+
+func (f *Fake) GetIncident(id string) (*pagerduty.Incident, error) {
+
+	if err := f.call("GetIncident"); err != nil {
+		return nil, err
+	}
+
+	for _, incident := range f.Incidents {
+		if incident.ID == id {
+			return &incident, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: incident %s not found", id)
+}
+
+func (f *Fake) ListIncidentNotes(incidentID string) ([]pagerduty.IncidentNote, error) {
+
+	if err := f.call("ListIncidentNotes"); err != nil {
+		return nil, err
+	}
+
+	for _, incident := range f.Incidents {
+		if incident.ID == incidentID {
+			return f.Notes[incidentID], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: incident %s not found", incidentID)
+}
+
+func (f *Fake) ListIncidentAlerts(incidentID string, o pagerduty.APIListObject) (*pagerdutysvc.ListIncidentAlertsResponse, error) {
+
+	if err := f.call("ListIncidentAlerts"); err != nil {
+		return nil, err
+	}
+
+	for _, incident := range f.Incidents {
+		if incident.ID == incidentID {
+			alerts := f.Alerts[incidentID]
+			start, end, list := page(len(alerts), o)
+
+			return &pagerdutysvc.ListIncidentAlertsResponse{APIListObject: list, Alerts: alerts[start:end]}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Failed call API endpoint. HTTP response code: 404. Error: incident %s not found", incidentID)
+}
+
+func (f *Fake) ListLogEntries(o pagerduty.ListLogEntriesOptions) (*pagerdutysvc.ListLogEntriesResponse, error) {
+
+	if err := f.call("ListLogEntries"); err != nil {
+		return nil, err
+	}
+
+	var entries []tools.PagerDutyLogEntry
+	for _, entry := range f.LogEntries {
+		ok, err := inRange(entry.CreatedAt, o.Since, o.Until)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	start, end, list := page(len(entries), o.APIListObject)
+
+	return &pagerdutysvc.ListLogEntriesResponse{APIListObject: list, LogEntries: entries[start:end]}, nil
+}
+
+func (f *Fake) ListOnCalls(o pagerduty.ListOnCallOptions) (*pagerduty.ListOnCallsResponse, error) {
+
+	if err := f.call("ListOnCalls"); err != nil {
+		return nil, err
+	}
+
+	var onCalls []pagerduty.OnCall
+	for _, onCall := range f.OnCalls {
+		ok, err := overlaps(onCall.Start, onCall.End, o.Since, o.Until)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			onCalls = append(onCalls, onCall)
+		}
+	}
+
+	start, end, list := page(len(onCalls), o.APIListObject)
+
+	return &pagerduty.ListOnCallsResponse{APIListObject: list, OnCalls: onCalls[start:end]}, nil
+}
+
+// page returns the bounds of the requested page of a list of total items, the API defaults to 25 items
+func page(total int, o pagerduty.APIListObject) (int, int, pagerduty.APIListObject) {
+
+	limit := int(o.Limit)
+	if limit == 0 {
+		limit = 25
+	}
+
+	start := int(o.Offset)
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	return start, end, pagerduty.APIListObject{Limit: uint(limit), Offset: o.Offset, More: end < total, Total: uint(total)}
+}
+
+// inRange reports whether createdAt falls in [since, until), an empty bound is open
+func inRange(createdAt string, since string, until string) (bool, error) {
+
+	created, err := parseTime(createdAt)
+	if err != nil {
+		return false, err
+	}
+
+	if since != "" {
+		from, err := parseTime(since)
+		if err != nil {
+			return false, err
+		}
+		if created.Before(from) {
+			return false, nil
+		}
+	}
+
+	if until != "" {
+		to, err := parseTime(until)
+		if err != nil {
+			return false, err
+		}
+		if !created.Before(to) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// overlaps reports whether a shift from start to end overlaps [since, until). Permanent on-calls
+// have neither start nor end and overlap everything.
+func overlaps(start string, end string, since string, until string) (bool, error) {
+
+	if start == "" || end == "" {
+		return true, nil
+	}
+
+	if until != "" {
+		ok, err := inRange(start, "", until)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if since != "" {
+		to, err := parseTime(end)
+		if err != nil {
+			return false, err
+		}
+		from, err := parseTime(since)
+		if err != nil {
+			return false, err
+		}
+		if !to.After(from) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// parseTime reads a since, until or record timestamp, like the API anything but ISO 8601 is rejected
+func parseTime(value string) (time.Time, error) {
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return parsed, fmt.Errorf("Failed call API endpoint. HTTP response code: 400. Error: invalid date %q", value)
+	}
+
+	return parsed, nil
+}
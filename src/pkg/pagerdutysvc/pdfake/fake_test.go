@@ -0,0 +1,150 @@
+package pdfake
+
+import (
+	".."
+	"errors"
+	"github.com/PagerDuty/go-pagerduty"
+	"testing"
+	"time"
+)
+
+var _ pagerdutysvc.Source = (*Fake)(nil)
+
+func TestDefaultFixtures(t *testing.T) {
+
+	fake := Default()
+
+	if len(fake.EscalationPolicies) == 0 || len(fake.Users) == 0 || len(fake.Schedules) == 0 ||
+		len(fake.Services) == 0 || len(fake.Incidents) == 0 || len(fake.LogEntries) == 0 {
+		t.Errorf("Expected every entity in the default fixtures, got %+v", fake)
+	}
+}
+
+func TestListUsersPages(t *testing.T) {
+
+	fake := Default()
+
+	first, err := fake.ListUsers(pagerduty.ListUsersOptions{APIListObject: pagerduty.APIListObject{Limit: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Users) != 2 || !first.More {
+		t.Errorf("Expected a full first page with more to come, got %d users, more %v", len(first.Users), first.More)
+	}
+
+	second, err := fake.ListUsers(pagerduty.ListUsersOptions{APIListObject: pagerduty.APIListObject{Limit: 2, Offset: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Users) != 1 || second.More {
+		t.Errorf("Expected the last user on the second page, got %d users, more %v", len(second.Users), second.More)
+	}
+
+	if fake.Calls("ListUsers") != 2 {
+		t.Errorf("Expected 2 calls, got %d", fake.Calls("ListUsers"))
+	}
+}
+
+func TestListIncidentsFiltersByDate(t *testing.T) {
+
+	fake := Default()
+	since := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	response, err := fake.ListIncidents(pagerduty.ListIncidentsOptions{Since: since.Format(time.RFC3339), Until: until.Format(time.RFC3339)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Incidents) != 2 {
+		t.Errorf("Expected 2 incidents in March 2018, got %d", len(response.Incidents))
+	}
+
+	// Like the API, Go's default time format isn't a date
+	_, err = fake.ListIncidents(pagerduty.ListIncidentsOptions{Since: since.String(), Until: until.String()})
+	if err == nil {
+		t.Errorf("Expected %q to be rejected", since.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	fake := Default()
+	fake.Errors = map[string]error{"ListLogEntries": errors.New("boom")}
+
+	_, err := fake.ListLogEntries(pagerduty.ListLogEntriesOptions{})
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("Expected the configured error, got %v", err)
+	}
+}
+
+func TestListOnCallsReturnsOverlappingShifts(t *testing.T) {
+
+	fake := Default()
+
+	response, err := fake.ListOnCalls(pagerduty.ListOnCallOptions{Since: "2018-03-04T00:00:00Z", Until: "2018-03-06T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both shifts around the change of hands on March 5th plus the permanent on-call
+	if len(response.OnCalls) != 3 {
+		t.Errorf("Expected 3 on-calls, got %d", len(response.OnCalls))
+	}
+}
+
+func TestGetScheduleRendersBetweenSinceAndUntil(t *testing.T) {
+
+	fake := Default()
+
+	schedule, err := fake.GetSchedule("PSC0001", pagerduty.GetScheduleOptions{Since: "2018-03-01T00:00:00Z", Until: "2018-03-08T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := schedule.FinalSchedule.RenderedScheduleEntries
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Start != "2018-03-01T00:00:00Z" || entries[2].End != "2018-03-08T12:00:00Z" {
+		t.Errorf("Expected the entries cut to since and until, got %+v", entries)
+	}
+	if len(fake.Schedules[0].FinalSchedule.RenderedScheduleEntries) != 4 {
+		t.Errorf("Expected the fixtures left untouched")
+	}
+
+	_, err = fake.GetSchedule("PSC9999", pagerduty.GetScheduleOptions{})
+	if err == nil {
+		t.Errorf("Expected an unknown schedule to fail")
+	}
+}
+
+func TestListServiceDependenciesReturnsBothSides(t *testing.T) {
+
+	fake := Default()
+
+	response, err := fake.ListServiceDependencies("technical_services", "PSV0002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Relationships) != 2 {
+		t.Errorf("Expected the service it depends on and the business service depending on it, got %+v", response.Relationships)
+	}
+}
+
+func TestListTeamMembers(t *testing.T) {
+
+	fake := Default()
+
+	response, err := fake.ListTeamMembers("PTM0001", pagerduty.APIListObject{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Members) != 1 || !response.More || response.Members[0].Role != "manager" {
+		t.Errorf("Expected the manager on a first page with more to come, got %+v", response)
+	}
+
+	_, err = fake.ListTeamMembers("PTM9999", pagerduty.APIListObject{})
+	if err == nil {
+		t.Errorf("Expected an unknown team to fail")
+	}
+}
@@ -0,0 +1,77 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"fmt"
+	"sync"
+)
+
+// ForEach calls fn for every index below n on up to PAGERDUTY_CONCURRENCY goroutines, for the
+// per-entity calls a transfer fans out to. fn stores its result at its index so results keep the
+// order of their entities. Once a call fails no new one is started, the error of the lowest
+// failing index is returned.
+func ForEach(n int, fn func(i int) error) error {
+
+	workers := tools.EnvironmentVariables.APIConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	errs := make([]error, n)
+	var mu sync.Mutex
+	next := 0
+	failed := false
+
+	// take hands out the next index, or false once everything is handed out or a call failed
+	take := func() (int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failed || next >= n {
+			return 0, false
+		}
+		next++
+		return next - 1, true
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i, ok := take(); ok; i, ok = take() {
+				errs[i] = call(fn, i)
+				if errs[i] != nil {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// call runs fn(i), a panic is returned as an error as nothing up a worker's stack would recover it
+func call(fn func(i int) error, i int) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fn(i)
+}
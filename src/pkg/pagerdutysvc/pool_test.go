@@ -0,0 +1,78 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEachBoundsConcurrency(t *testing.T) {
+
+	tools.EnvironmentVariables.APIConcurrency = 3
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	results := make([]int, 20)
+
+	err := ForEach(len(results), func(i int) error {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		results[i] = i * i
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if most != 3 {
+		t.Errorf("Expected 3 calls at once, got %d", most)
+	}
+	for i, result := range results {
+		if result != i*i {
+			t.Errorf("Expected result %d at %d, got %d", i*i, i, result)
+		}
+	}
+}
+
+func TestForEachStopsAfterError(t *testing.T) {
+
+	tools.EnvironmentVariables.APIConcurrency = 1
+
+	calls := 0
+	err := ForEach(10, func(i int) error {
+		calls++
+		if i == 2 {
+			return errors.New("not found")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "not found" || calls != 3 {
+		t.Errorf("Expected to stop at the failing call, got %d calls and %v", calls, err)
+	}
+}
+
+func TestForEachRecoversPanic(t *testing.T) {
+
+	tools.EnvironmentVariables.APIConcurrency = 2
+
+	err := ForEach(4, func(i int) error {
+		if i == 1 {
+			panic("nil schedule")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "panic: nil schedule" {
+		t.Errorf("Expected the panic as an error, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package pagerdutysvc
+
+import (
+	"context"
+	"fmt"
+	"github.com/PagerDuty/go-pagerduty"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetryingClient is the HTTP client behind every PagerDuty request. Rate limited (429) and 5xx
+// responses as well as network errors are retried with exponential backoff and full jitter, or
+// after the delay the API asks for in Retry-After or ratelimit-reset. A request gives up after
+// MaxAttempts or once waiting any longer would exceed Budget, and returns the last response.
+type RetryingClient struct {
+	Client      pagerduty.HTTPClient
+	MaxAttempts int
+	Budget      time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// MinInterval spaces out the attempts of every request sharing the client, concurrent ones
+	// included, to stay under the API rate limit. Zero doesn't throttle.
+	MinInterval time.Duration
+
+	// Sleep waits between attempts, replaced in tests
+	Sleep func(time.Duration)
+
+	mu       sync.Mutex
+	next     time.Time
+	bound    context.Context
+	requests uint64
+}
+
+// NewRetryingClient wraps http.DefaultClient
+func NewRetryingClient(maxAttempts int, budget time.Duration) *RetryingClient {
+
+	return &RetryingClient{
+		Client:      http.DefaultClient,
+		MaxAttempts: maxAttempts,
+		Budget:      budget,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+	}
+}
+
+// Bind ties the requests sent from now on to ctx: waiting for a slot or between attempts stops once
+// ctx is done, and retries never wait past its deadline. go-pagerduty builds its requests without a
+// context, so the handler binds every invocation's.
+func (c *RetryingClient) Bind(ctx context.Context) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bound = ctx
+}
+
+// Context is the context bound last, context.Background() until Bind is called
+func (c *RetryingClient) Context() context.Context {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bound == nil {
+		return context.Background()
+	}
+	return c.bound
+}
+
+func (c *RetryingClient) Do(req *http.Request) (*http.Response, error) {
+
+	var waited time.Duration
+
+	// Numbers the log lines of a request, concurrent ones interleave
+	id := atomic.AddUint64(&c.requests, 1)
+
+	ctx := c.Context()
+	req = req.WithContext(ctx)
+
+	// Waiting past the deadline is pointless, the function is stopped by then
+	budget := c.Budget
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < budget {
+		budget = time.Until(deadline)
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		if err := c.throttle(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.Client.Do(req)
+
+		reason, retry := retryReason(resp, err)
+		if !retry || attempt >= c.MaxAttempts || ctx.Err() != nil || !c.rewind(req) {
+			return resp, err
+		}
+
+		delay := c.delay(resp, attempt)
+		if waited+delay > budget {
+			fmt.Println("PagerDuty retry budget exhausted: request", id, req.Method, req.URL.Path, reason)
+			return resp, err
+		}
+
+		// The response is thrown away, let the connection be reused
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		fmt.Println("Retrying PagerDuty request", id, req.Method, req.URL.Path, "in", delay, "after", reason,
+			"attempt", attempt+1, "of", c.MaxAttempts)
+
+		if err := c.wait(req, delay); err != nil {
+			return nil, err
+		}
+		waited += delay
+	}
+}
+
+// throttle waits for the request's slot, slots are handed out MinInterval apart in call order
+func (c *RetryingClient) throttle(req *http.Request) error {
+
+	if c.MinInterval <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	delay := c.next.Sub(now)
+	c.next = c.next.Add(c.MinInterval)
+	c.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	return c.wait(req, delay)
+}
+
+// retryReason tells whether a response or error is worth retrying and why
+func retryReason(resp *http.Response, err error) (string, bool) {
+
+	switch {
+	case err != nil:
+		return err.Error(), true
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "rate limit (HTTP 429)", true
+	case resp.StatusCode >= 500:
+		return fmt.Sprintf("HTTP %d", resp.StatusCode), true
+	default:
+		return "", false
+	}
+}
+
+// rewind prepares the request body to be sent again, requests that can't be replayed aren't retried
+func (c *RetryingClient) rewind(req *http.Request) bool {
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+
+	return true
+}
+
+// delay honours the wait the API asked for and falls back to exponential backoff with full jitter
+func (c *RetryingClient) delay(resp *http.Response, attempt int) time.Duration {
+
+	if resp != nil {
+		if delay, ok := requestedDelay(resp.Header, time.Now()); ok {
+			return delay
+		}
+	}
+
+	backoff := c.BaseDelay << uint(attempt-1)
+	if backoff <= 0 || backoff > c.MaxDelay {
+		backoff = c.MaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// requestedDelay reads Retry-After, in seconds or as an HTTP date, or the seconds until the
+// rate limit window resets
+func requestedDelay(header http.Header, now time.Time) (time.Duration, bool) {
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			if at.Before(now) {
+				return 0, true
+			}
+			return at.Sub(now), true
+		}
+	}
+
+	for _, name := range []string{"Ratelimit-Reset", "X-Ratelimit-Reset"} {
+		if seconds, err := strconv.Atoi(header.Get(name)); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return 0, false
+}
+
+// wait sleeps for delay unless the request is cancelled first
+func (c *RetryingClient) wait(req *http.Request, delay time.Duration) error {
+
+	if c.Sleep != nil {
+		c.Sleep(delay)
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
@@ -0,0 +1,261 @@
+package pagerdutysvc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingServer answers with the given status codes in turn, then 200
+func failingServer(t *testing.T, statuses []int, header http.Header) (*httptest.Server, *int) {
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= len(statuses) {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(statuses[calls-1])
+			w.Write([]byte(`{"error":{"message":"try again"}}`))
+			return
+		}
+		w.Write([]byte(`{"users":[{"id":"PUS0001","name":"Ada Lovelace"}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func testClient(maxAttempts int, budget time.Duration) (*RetryingClient, *[]time.Duration) {
+
+	var slept []time.Duration
+	client := NewRetryingClient(maxAttempts, budget)
+	client.Sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	return client, &slept
+}
+
+func TestRetryingClientRetriesServerErrors(t *testing.T) {
+
+	server, calls := failingServer(t, []int{503, 502}, nil)
+	client, slept := testClient(5, time.Minute)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || *calls != 3 || len(*slept) != 2 {
+		t.Errorf("Expected success on the third attempt, got status %d after %d calls and %d waits", resp.StatusCode, *calls, len(*slept))
+	}
+	for i, delay := range *slept {
+		if delay > client.BaseDelay<<uint(i) {
+			t.Errorf("Backoff %d exceeds its cap: %v", i, delay)
+		}
+	}
+}
+
+func TestRetryingClientHonoursRetryAfter(t *testing.T) {
+
+	server, _ := failingServer(t, []int{429}, http.Header{"Retry-After": {"7"}})
+	client, slept := testClient(5, time.Minute)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(*slept) != 1 || (*slept)[0] != 7*time.Second {
+		t.Errorf("Expected a single 7s wait, got %v", *slept)
+	}
+}
+
+func TestRetryingClientHonoursRateLimitReset(t *testing.T) {
+
+	server, _ := failingServer(t, []int{429}, http.Header{"Ratelimit-Reset": {"12"}})
+	client, slept := testClient(5, time.Minute)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(*slept) != 1 || (*slept)[0] != 12*time.Second {
+		t.Errorf("Expected a single 12s wait, got %v", *slept)
+	}
+}
+
+func TestRetryingClientGivesUp(t *testing.T) {
+
+	for name, test := range map[string]struct {
+		statuses    []int
+		header      http.Header
+		maxAttempts int
+		budget      time.Duration
+		calls       int
+		status      int
+	}{
+		"client error":     {[]int{400}, nil, 5, time.Minute, 1, 400},
+		"max attempts":     {[]int{500, 500, 500}, nil, 3, time.Minute, 3, 500},
+		"budget exhausted": {[]int{429, 429}, http.Header{"Retry-After": {"40"}}, 5, time.Minute, 2, 429},
+		"budget too small": {[]int{429}, http.Header{"Retry-After": {"90"}}, 5, time.Minute, 1, 429},
+	} {
+		server, calls := failingServer(t, test.statuses, test.header)
+		client, _ := testClient(test.maxAttempts, test.budget)
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status || *calls != test.calls {
+			t.Errorf("%s: expected HTTP %d after %d calls, got HTTP %d after %d calls", name, test.status, test.calls, resp.StatusCode, *calls)
+		}
+	}
+}
+
+func TestRetryingClientRetriesNetworkErrors(t *testing.T) {
+
+	server, _ := failingServer(t, nil, nil)
+	target := server.URL
+	server.Close()
+
+	client, slept := testClient(3, time.Minute)
+
+	req, _ := http.NewRequest("GET", target, nil)
+	_, err := client.Do(req)
+	if err == nil {
+		t.Fatal("Expected a connection error")
+	}
+	if len(*slept) != 2 {
+		t.Errorf("Expected 2 waits before giving up, got %d", len(*slept))
+	}
+}
+
+func TestRequestedDelay(t *testing.T) {
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		header http.Header
+		delay  time.Duration
+		ok     bool
+	}{
+		{http.Header{"Retry-After": {"3"}}, 3 * time.Second, true},
+		{http.Header{"Retry-After": {now.Add(20 * time.Second).Format(http.TimeFormat)}}, 20 * time.Second, true},
+		{http.Header{"X-Ratelimit-Reset": {"5"}}, 5 * time.Second, true},
+		{http.Header{}, 0, false},
+	} {
+		delay, ok := requestedDelay(test.header, now)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("%v: expected %v %v, got %v %v", test.header, test.delay, test.ok, delay, ok)
+		}
+	}
+}
+
+func TestClientGet(t *testing.T) {
+
+	server, _ := failingServer(t, []int{503}, nil)
+	retrying, _ := testClient(3, time.Minute)
+	client := &Client{apiKey: "key", http: retrying, endpoint: server.URL}
+
+	var out struct {
+		Users []struct {
+			ID string `json:"id"`
+		} `json:"users"`
+	}
+	err := client.get("/users", url.Values{"limit": {"1"}}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Users) != 1 || out.Users[0].ID != "PUS0001" {
+		t.Errorf("Unexpected response: %+v", out)
+	}
+
+	failing, _ := failingServer(t, []int{404}, nil)
+	client.endpoint = failing.URL
+	err = client.get("/users", nil, &out)
+
+	if err == nil || !strings.Contains(err.Error(), "HTTP response code: 404") {
+		t.Fatalf("Expected a go-pagerduty style error, got %v", err)
+	}
+	if apiErr, ok := wrapError("get users", err).(*APIError); !ok || apiErr.StatusCode != 404 {
+		t.Errorf("Expected an APIError with status 404, got %v", err)
+	}
+}
+
+func TestRetryingClientSpacesRequests(t *testing.T) {
+
+	server, calls := failingServer(t, nil, nil)
+	client, slept := testClient(5, time.Minute)
+	client.MinInterval = time.Second
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// The first request goes straight away, Sleep doesn't actually wait so slots pile up
+	if *calls != 3 || len(*slept) != 2 {
+		t.Fatalf("Expected 3 calls and 2 waits, got %d and %v", *calls, *slept)
+	}
+	if (*slept)[0] <= 900*time.Millisecond || (*slept)[1] <= time.Second+900*time.Millisecond {
+		t.Errorf("Expected requests a second apart, waited %v", *slept)
+	}
+}
+
+func TestRetryingClientStopsAtDeadline(t *testing.T) {
+
+	server, calls := failingServer(t, []int{503}, http.Header{"Retry-After": {"5"}})
+	client, slept := testClient(5, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client.Bind(ctx)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Retry-After asks for longer than the invocation has left
+	if resp.StatusCode != 503 || *calls != 1 || len(*slept) != 0 {
+		t.Errorf("Expected to give up after one attempt, got status %d after %d calls and %d waits", resp.StatusCode, *calls, len(*slept))
+	}
+}
+
+func TestClientGetFollowsBoundContext(t *testing.T) {
+
+	server, calls := failingServer(t, nil, nil)
+	retrying, _ := testClient(3, time.Minute)
+	client := &Client{apiKey: "key", http: retrying, endpoint: server.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.Bind(ctx)
+
+	var out struct{}
+	err := client.get("/users", nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") || *calls != 0 {
+		t.Errorf("Expected the cancelled invocation to stop the request, got %v after %d calls", err, *calls)
+	}
+}
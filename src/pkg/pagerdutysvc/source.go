@@ -0,0 +1,309 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PagerDuty/go-pagerduty"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Source is the PagerDuty API used by the transfers, one method per endpoint, list endpoints return a
+// single page. Client implements it against the real API, tests use the in-memory pdfake.Fake.
+type Source interface {
+	ListEscalationPolicies(pagerduty.ListEscalationPoliciesOptions) (*pagerduty.ListEscalationPoliciesResponse, error)
+	ListEscalationRules(escID string) (*pagerduty.ListEscalationRulesResponse, error)
+	ListUsers(pagerduty.ListUsersOptions) (*ListUsersResponse, error)
+	ListSchedules(pagerduty.ListSchedulesOptions) (*pagerduty.ListSchedulesResponse, error)
+	GetSchedule(id string, o pagerduty.GetScheduleOptions) (*pagerduty.Schedule, error)
+	ListOverrides(id string, o pagerduty.ListOverridesOptions) ([]pagerduty.Override, error)
+	ListServices(pagerduty.ListServiceOptions) (*pagerduty.ListServiceResponse, error)
+	ListBusinessServices(pagerduty.APIListObject) (*ListBusinessServicesResponse, error)
+	ListServiceDependencies(kind string, id string) (*ListServiceDependenciesResponse, error)
+	ListPriorities(pagerduty.APIListObject) (*ListPrioritiesResponse, error)
+	ListTeams(pagerduty.ListTeamOptions) (*pagerduty.ListTeamResponse, error)
+	ListTeamMembers(teamID string, o pagerduty.APIListObject) (*ListTeamMembersResponse, error)
+	ListIncidents(pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error)
+	GetIncident(id string) (*pagerduty.Incident, error)
+	ListIncidentNotes(incidentID string) ([]pagerduty.IncidentNote, error)
+	ListIncidentAlerts(incidentID string, o pagerduty.APIListObject) (*ListIncidentAlertsResponse, error)
+	ListLogEntries(pagerduty.ListLogEntriesOptions) (*ListLogEntriesResponse, error)
+	ListOnCalls(pagerduty.ListOnCallOptions) (*pagerduty.ListOnCallsResponse, error)
+}
+
+// ListLogEntriesResponse is a page of log entries decoded with every field the transfers store
+type ListLogEntriesResponse struct {
+	pagerduty.APIListObject
+	LogEntries []tools.PagerDutyLogEntry `json:"log_entries"`
+}
+
+// ListUsersResponse is a page of users with their time zones and the included contact methods and
+// notification rules
+type ListUsersResponse struct {
+	pagerduty.APIListObject
+	Users []tools.PagerDutyUser `json:"users"`
+}
+
+// ListIncidentAlertsResponse is a page of an incident's alerts, bodies included
+type ListIncidentAlertsResponse struct {
+	pagerduty.APIListObject
+	Alerts []tools.PagerDutyAlert `json:"alerts"`
+}
+
+// ListBusinessServicesResponse is a page of business services
+type ListBusinessServicesResponse struct {
+	pagerduty.APIListObject
+	BusinessServices []tools.PagerDutyBusinessService `json:"business_services"`
+}
+
+// ListServiceDependenciesResponse lists the relationships of a service, on both of its sides
+type ListServiceDependenciesResponse struct {
+	Relationships []tools.PagerDutyServiceDependency `json:"relationships"`
+}
+
+// Kinds of services accepted by ListServiceDependencies
+const (
+	BusinessServices  = "business_services"
+	TechnicalServices = "technical_services"
+)
+
+// ListPrioritiesResponse is a page of the account's incident priorities
+type ListPrioritiesResponse struct {
+	pagerduty.APIListObject
+	Priorities []tools.PagerDutyPriority `json:"priorities"`
+}
+
+// ListTeamMembersResponse is a page of a team's members with their roles
+type ListTeamMembersResponse struct {
+	pagerduty.APIListObject
+	Members []tools.PagerDutyTeamMember `json:"members"`
+}
+
+var _ Source = (*Client)(nil)
+
+const apiEndpoint = "https://api.pagerduty.com"
+
+// Client is the real Source. go-pagerduty serves the endpoints it covers, get reaches the rest,
+// and both send every request through the same RetryingClient.
+type Client struct {
+	*pagerduty.Client
+	apiKey   string
+	http     *RetryingClient
+	endpoint string
+}
+
+// NewClient returns a client for apiKey retrying up to PAGERDUTY_MAX_ATTEMPTS times within
+// PAGERDUTY_RETRY_BUDGET seconds
+func NewClient(apiKey string) *Client {
+
+	retrying := NewRetryingClient(tools.EnvironmentVariables.APIMaxAttempts,
+		time.Duration(tools.EnvironmentVariables.APIRetryBudget)*time.Second)
+	if tools.EnvironmentVariables.APIRateLimit > 0 {
+		retrying.MinInterval = time.Minute / time.Duration(tools.EnvironmentVariables.APIRateLimit)
+	}
+
+	client := pagerduty.NewClient(apiKey)
+	client.HTTPClient = retrying
+
+	return &Client{Client: client, apiKey: apiKey, http: retrying, endpoint: apiEndpoint}
+}
+
+// Bind ties the client's requests to ctx, see RetryingClient.Bind
+func (c *Client) Bind(ctx context.Context) {
+
+	c.http.Bind(ctx)
+}
+
+// get decodes the JSON response of a GET request to path into out. Failures read like go-pagerduty's
+// so wrapError classifies both the same way.
+func (c *Client) get(path string, query url.Values, out interface{}) error {
+
+	target := c.endpoint + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(c.http.Context(), "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.pagerduty+json;version=2")
+	req.Header.Set("Authorization", "Token token="+c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error calling the API endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed call API endpoint. HTTP response code: %d. Error: %s", resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ListIncidents is read through get to ask for the total, go-pagerduty can't. Windows are sized
+// from the total of their first page.
+func (c *Client) ListIncidents(o pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+	query.Set("total", "true")
+	for name, value := range map[string]string{"since": o.Since, "until": o.Until, "date_range": o.DateRange,
+		"incident_key": o.IncidentKey, "time_zone": o.TimeZone, "sort_by": o.SortBy} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	for name, values := range map[string][]string{"statuses[]": o.Statuses, "service_ids[]": o.ServiceIDs,
+		"team_ids[]": o.TeamIDs, "user_ids[]": o.UserIDs, "urgencies[]": o.Urgencies, "include[]": o.Includes} {
+		for _, value := range values {
+			query.Add(name, value)
+		}
+	}
+
+	var response pagerduty.ListIncidentsResponse
+	err := c.get("/incidents", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListLogEntries is read through get, go-pagerduty's LogEntry drops most of the fields we store.
+// The total is asked for like ListIncidents'.
+func (c *Client) ListLogEntries(o pagerduty.ListLogEntriesOptions) (*ListLogEntriesResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+	query.Set("total", "true")
+	if o.TimeZone != "" {
+		query.Set("time_zone", o.TimeZone)
+	}
+	if o.Since != "" {
+		query.Set("since", o.Since)
+	}
+	if o.Until != "" {
+		query.Set("until", o.Until)
+	}
+	if o.IsOverview {
+		query.Set("is_overview", "true")
+	}
+	for _, include := range o.Includes {
+		query.Add("include[]", include)
+	}
+
+	var response ListLogEntriesResponse
+	err := c.get("/log_entries", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListUsers is read through get, go-pagerduty's User drops the time zone
+func (c *Client) ListUsers(o pagerduty.ListUsersOptions) (*ListUsersResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+	for _, include := range o.Includes {
+		query.Add("include[]", include)
+	}
+
+	var response ListUsersResponse
+	err := c.get("/users", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListTeamMembers is read through get, go-pagerduty doesn't cover /teams/{id}/members
+func (c *Client) ListTeamMembers(teamID string, o pagerduty.APIListObject) (*ListTeamMembersResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+
+	var response ListTeamMembersResponse
+	err := c.get("/teams/"+url.PathEscape(teamID)+"/members", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListIncidentAlerts is read through get, go-pagerduty's IncidentAlert has no body
+func (c *Client) ListIncidentAlerts(incidentID string, o pagerduty.APIListObject) (*ListIncidentAlertsResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+
+	var response ListIncidentAlertsResponse
+	err := c.get("/incidents/"+url.PathEscape(incidentID)+"/alerts", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListPriorities is read through get, go-pagerduty doesn't cover /priorities
+func (c *Client) ListPriorities(o pagerduty.APIListObject) (*ListPrioritiesResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+
+	var response ListPrioritiesResponse
+	err := c.get("/priorities", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListBusinessServices is read through get, go-pagerduty doesn't cover /business_services
+func (c *Client) ListBusinessServices(o pagerduty.APIListObject) (*ListBusinessServicesResponse, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	query.Set("offset", strconv.FormatUint(uint64(o.Offset), 10))
+
+	var response ListBusinessServicesResponse
+	err := c.get("/business_services", query, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// ListServiceDependencies is read through get, kind is BusinessServices or TechnicalServices. The
+// endpoint isn't paginated.
+func (c *Client) ListServiceDependencies(kind string, id string) (*ListServiceDependenciesResponse, error) {
+
+	var response ListServiceDependenciesResponse
+	err := c.get("/service_dependencies/"+kind+"/"+url.PathEscape(id), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
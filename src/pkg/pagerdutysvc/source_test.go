@@ -0,0 +1,128 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"github.com/PagerDuty/go-pagerduty"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListTeamMembers(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/teams/PTM0001/members" || r.URL.Query().Get("offset") != "2" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Token token=key" {
+			t.Errorf("Unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"members":[{"user":{"id":"PUS0001"},"role":"manager"}],"limit":2,"offset":2,"more":false}`))
+	}))
+	defer server.Close()
+
+	client := NewClient("key")
+	client.endpoint = server.URL
+
+	response, err := client.ListTeamMembers("PTM0001", pagerduty.APIListObject{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Members) != 1 || response.Members[0].User.ID != "PUS0001" || response.Members[0].Role != "manager" {
+		t.Errorf("Expected the manager, got %+v", response.Members)
+	}
+}
+
+func TestListPriorities(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/priorities" || r.URL.Query().Get("limit") != "25" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"priorities":[{"id":"PPR0001","name":"P1","color":"a8171c","order":512}],"limit":25,"offset":0,"more":false}`))
+	}))
+	defer server.Close()
+
+	client := NewClient("key")
+	client.endpoint = server.URL
+
+	response, err := client.ListPriorities(pagerduty.APIListObject{Limit: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Priorities) != 1 || response.Priorities[0].Name != "P1" || response.Priorities[0].Order != 512 {
+		t.Errorf("Expected P1, got %+v", response.Priorities)
+	}
+}
+
+func TestListUsersReadsTimeZone(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users" || r.URL.Query()["include[]"][1] != "notification_rules" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"users":[{"id":"PUS0001","time_zone":"Europe/London","contact_methods":[{"id":"PCM0001"}]}],"more":false}`))
+	}))
+	defer server.Close()
+
+	client := NewClient("key")
+	client.endpoint = server.URL
+
+	response, err := client.ListUsers(pagerduty.ListUsersOptions{Includes: []string{"contact_methods", "notification_rules"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Users) != 1 || response.Users[0].TimeZone != "Europe/London" || len(response.Users[0].ContactMethods) != 1 {
+		t.Errorf("Expected the user with time zone and contact method, got %+v", response.Users)
+	}
+}
+
+func TestEachLogEntryPageSendsRFC3339(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("since") != "2018-03-01T00:00:00Z" || query.Get("until") != "2018-03-02T00:00:00Z" || query.Get("time_zone") != "UTC" ||
+			query.Get("total") != "true" {
+			t.Errorf("Unexpected query %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"log_entries":[],"more":false}`))
+	}))
+	defer server.Close()
+
+	client := NewClient("key")
+	client.endpoint = server.URL
+
+	since := time.Date(2018, 3, 1, 1, 0, 0, 0, time.FixedZone("CET", 3600))
+	_, err := EachLogEntryPage(client, NewWindow(since, since.Add(24*time.Hour)), func(page []tools.PagerDutyLogEntry) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListIncidentsReadsTotal(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/incidents" || query.Get("total") != "true" || query.Get("since") != "2018-03-01T00:00:00Z" ||
+			query.Get("time_zone") != "UTC" || query["statuses[]"][0] != "resolved" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"incidents":[{"id":"PIN0001"}],"limit":1,"offset":0,"total":12000,"more":true}`))
+	}))
+	defer server.Close()
+
+	client := NewClient("key")
+	client.endpoint = server.URL
+
+	response, err := client.ListIncidents(pagerduty.ListIncidentsOptions{APIListObject: pagerduty.APIListObject{Limit: 1},
+		Since: "2018-03-01T00:00:00Z", TimeZone: "UTC", Statuses: []string{"resolved"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Incidents) != 1 || response.Total != 12000 {
+		t.Errorf("Expected one incident out of 12000, got %+v", response)
+	}
+}
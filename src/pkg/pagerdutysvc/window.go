@@ -0,0 +1,107 @@
+package pagerdutysvc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Window is the half-open range [Since, Until) of a windowed list call, in UTC and whole seconds.
+// Consecutive windows share their boundary, each record falls in exactly one of them.
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// NewWindow returns the window from since to until. Both ends are truncated to the second the
+// API works in, so windows built from adjacent ranges stay adjacent.
+func NewWindow(since time.Time, until time.Time) Window {
+
+	return Window{Since: since.UTC().Truncate(time.Second), Until: until.UTC().Truncate(time.Second)}
+}
+
+// SinceParam is Since as sent in the since query parameter
+func (w Window) SinceParam() string {
+
+	return w.Since.Format(time.RFC3339)
+}
+
+// UntilParam is Until as sent in the until query parameter
+func (w Window) UntilParam() string {
+
+	return w.Until.Format(time.RFC3339)
+}
+
+// Duration is the length of the window
+func (w Window) Duration() time.Duration {
+
+	return w.Until.Sub(w.Since)
+}
+
+// Contains tells whether t falls in the window, Until excluded
+func (w Window) Contains(t time.Time) bool {
+
+	return !t.Before(w.Since) && t.Before(w.Until)
+}
+
+// Split halves the window on a whole second
+func (w Window) Split() (Window, Window) {
+
+	middle := w.Since.Add(w.Duration() / 2).Truncate(time.Second)
+	if !middle.After(w.Since) {
+		middle = w.Since.Add(time.Second)
+	}
+
+	return Window{Since: w.Since, Until: middle}, Window{Since: middle, Until: w.Until}
+}
+
+func (w Window) String() string {
+
+	return fmt.Sprintf("[%s, %s)", w.SinceParam(), w.UntilParam())
+}
+
+// MaxOffset is the furthest classic pagination reaches, offset plus limit can't go beyond it
+const MaxOffset = 10000
+
+// errWindowTooLarge is returned by a list call whose next page would pass MaxOffset
+var errWindowTooLarge = errors.New("window has more records than pagination reaches")
+
+// pastMaxOffset tells whether a list call asking for the page at offset has to give up
+func pastMaxOffset(offset uint, limit uint) bool {
+
+	return offset+limit > MaxOffset
+}
+
+// splitWindow calls fetch over window. A window fetch rejects with errWindowTooLarge is split and
+// both halves are fetched in order, recursively. fetch decides from the first page's total, so it
+// rejects a window before handing any of its records on; only a window that grew past pagination
+// after its first page has records handed on twice. It returns the duration of the smallest window
+// that was fetched in full.
+func splitWindow(window Window, fetch func(window Window) error) (time.Duration, error) {
+
+	err := fetch(window)
+	if err != errWindowTooLarge {
+		return window.Duration(), err
+	}
+
+	if window.Duration() <= time.Second {
+		return 0, fmt.Errorf("window %s: %w", window, err)
+	}
+
+	first, second := window.Split()
+	fmt.Println("Splitting window", window, "into", first, "and", second)
+
+	firstSize, err := splitWindow(first, fetch)
+	if err != nil {
+		return 0, err
+	}
+	secondSize, err := splitWindow(second, fetch)
+	if err != nil {
+		return 0, err
+	}
+
+	if secondSize < firstSize {
+		return secondSize, nil
+	}
+	return firstSize, nil
+}
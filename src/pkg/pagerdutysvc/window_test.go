@@ -0,0 +1,209 @@
+package pagerdutysvc
+
+import (
+	"../tools"
+	"errors"
+	"github.com/PagerDuty/go-pagerduty"
+	"sort"
+	"testing"
+	"time"
+)
+
+// incidentSource lists one incident per minute, every other Source method is left unimplemented.
+// Pages report the window's total unless noTotal is set.
+type incidentSource struct {
+	Source
+	createdAt []time.Time
+	incidents []pagerduty.Incident
+	noTotal   bool
+	calls     int
+}
+
+func newIncidentSource(start time.Time, count int) *incidentSource {
+
+	src := &incidentSource{}
+	for i := 0; i < count; i++ {
+		createdAt := start.Add(time.Duration(i) * time.Minute)
+		src.createdAt = append(src.createdAt, createdAt)
+		src.incidents = append(src.incidents, pagerduty.Incident{CreatedAt: createdAt.Format(time.RFC3339)})
+	}
+
+	return src
+}
+
+func (s *incidentSource) ListIncidents(o pagerduty.ListIncidentsOptions) (*pagerduty.ListIncidentsResponse, error) {
+
+	since, _ := time.Parse(time.RFC3339, o.Since)
+	until, _ := time.Parse(time.RFC3339, o.Until)
+
+	// Incidents are in created order, those in [since, until) are a contiguous slice
+	first := sort.Search(len(s.createdAt), func(i int) bool { return !s.createdAt[i].Before(since) })
+	last := sort.Search(len(s.createdAt), func(i int) bool { return !s.createdAt[i].Before(until) })
+	incidents := s.incidents[first:last]
+
+	end := int(o.Offset + o.Limit)
+	if end > len(incidents) {
+		end = len(incidents)
+	}
+
+	list := pagerduty.APIListObject{Limit: o.Limit, Offset: o.Offset, More: end < len(incidents)}
+	if !s.noTotal {
+		list.Total = uint(len(incidents))
+	}
+	s.calls++
+
+	return &pagerduty.ListIncidentsResponse{APIListObject: list, Incidents: incidents[o.Offset:end]}, nil
+}
+
+func TestEachIncidentPageSplitsWindowsPastMaxOffset(t *testing.T) {
+
+	tools.EnvironmentVariables.PaginationLimit = 100
+
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	src := newIncidentSource(start, 12000)
+
+	// 12000 incidents don't fit, two halves of 6000 do
+	pages := 0
+	var incidents []pagerduty.Incident
+	seen := make(map[string]bool)
+	size, err := EachIncidentPage(src, NewWindow(start, start.Add(12000*time.Minute)), func(page []pagerduty.Incident) error {
+		if len(page) > 100 {
+			t.Errorf("Expected pages of at most 100 incidents, got %d", len(page))
+		}
+		pages++
+		for _, incident := range page {
+			if !seen[incident.CreatedAt] {
+				seen[incident.CreatedAt] = true
+				incidents = append(incidents, incident)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incidents) != 12000 {
+		t.Errorf("Expected 12000 incidents, got %d", len(incidents))
+	}
+	if size != 6000*time.Minute {
+		t.Errorf("Expected to settle on 100h windows, got %v", size)
+	}
+	if incidents[5999].CreatedAt >= incidents[6000].CreatedAt {
+		t.Errorf("Expected the halves in order, got %s before %s", incidents[5999].CreatedAt, incidents[6000].CreatedAt)
+	}
+
+	// The total of the first page split the window, none of its incidents were handed twice
+	if pages != 120 || len(seen) != 12000 {
+		t.Errorf("Expected 120 pages of distinct incidents, got %d pages of %d", pages, len(seen))
+	}
+	if src.calls != 121 {
+		t.Errorf("Expected the oversized window to cost a single request, got %d", src.calls)
+	}
+}
+
+func TestEachIncidentPageSplitsWithoutTotal(t *testing.T) {
+
+	tools.EnvironmentVariables.PaginationLimit = 100
+
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	src := newIncidentSource(start, 12000)
+	src.noTotal = true
+
+	// Without a total the window is only found too large at MaxOffset, its first half hands the
+	// 10000 incidents already handed again
+	pages := 0
+	seen := make(map[string]bool)
+	_, err := EachIncidentPage(src, NewWindow(start, start.Add(12000*time.Minute)), func(page []pagerduty.Incident) error {
+		pages++
+		for _, incident := range page {
+			seen[incident.CreatedAt] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 220 || len(seen) != 12000 {
+		t.Errorf("Expected 100 pages before the split and 120 after, got %d pages of %d incidents", pages, len(seen))
+	}
+}
+
+func TestEachIncidentPageStopsOnError(t *testing.T) {
+
+	tools.EnvironmentVariables.PaginationLimit = 100
+
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	src := newIncidentSource(start, 1000)
+
+	pages := 0
+	_, err := EachIncidentPage(src, NewWindow(start, start.Add(1000*time.Minute)), func(page []pagerduty.Incident) error {
+		pages++
+		if pages == 3 {
+			return errors.New("store unavailable")
+		}
+		return nil
+	})
+	if err == nil || pages != 3 {
+		t.Errorf("Expected to stop at the failing page, got %d pages and %v", pages, err)
+	}
+}
+
+func TestSplitWindow(t *testing.T) {
+
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	var fetched []time.Time
+
+	// Ranges up to 6 hours fit
+	size, err := splitWindow(NewWindow(start, start.Add(24*time.Hour)), func(window Window) error {
+		if window.Duration() > 6*time.Hour {
+			return errWindowTooLarge
+		}
+		fetched = append(fetched, window.Since)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 6*time.Hour {
+		t.Errorf("Expected 6h, got %v", size)
+	}
+	if len(fetched) != 4 || !fetched[3].Equal(start.Add(18*time.Hour)) {
+		t.Errorf("Expected 4 consecutive quarters, got %v", fetched)
+	}
+
+	// A second that still doesn't fit can't be split any further
+	_, err = splitWindow(NewWindow(start, start.Add(time.Minute)), func(window Window) error {
+		return errWindowTooLarge
+	})
+	if err == nil {
+		t.Errorf("Expected an error once windows can't be split")
+	}
+}
+
+func TestWindow(t *testing.T) {
+
+	paris := time.FixedZone("CET", 3600)
+	window := NewWindow(time.Date(2018, 3, 1, 1, 0, 0, 500, paris), time.Date(2018, 3, 2, 1, 0, 0, 0, paris))
+
+	// Sent in UTC to the second, never as Go's default "2018-03-01 01:00:00.0000005 +0100 CET"
+	if window.SinceParam() != "2018-03-01T00:00:00Z" || window.UntilParam() != "2018-03-02T00:00:00Z" {
+		t.Errorf("Expected RFC3339 UTC parameters, got %s", window)
+	}
+	if window.Duration() != 24*time.Hour {
+		t.Errorf("Expected 24h, got %v", window.Duration())
+	}
+	if !window.Contains(window.Since) || window.Contains(window.Until) {
+		t.Errorf("Expected %s to include Since and exclude Until", window)
+	}
+
+	first, second := window.Split()
+	if first.UntilParam() != "2018-03-01T12:00:00Z" || !first.Until.Equal(second.Since) || !second.Until.Equal(window.Until) {
+		t.Errorf("Expected two adjacent halves, got %s and %s", first, second)
+	}
+
+	// Odd seconds split on a whole second
+	first, _ = NewWindow(window.Since, window.Since.Add(3*time.Second)).Split()
+	if first.UntilParam() != "2018-03-01T00:00:01Z" {
+		t.Errorf("Expected a split on 00:00:01, got %s", first)
+	}
+}
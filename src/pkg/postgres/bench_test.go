@@ -0,0 +1,60 @@
+package postgres_test
+
+import (
+	"../tools"
+	"./postgrestest"
+	"fmt"
+	"github.com/PagerDuty/go-pagerduty"
+	"testing"
+)
+
+func benchmarkLogEntries(count int) []tools.LogEntry {
+
+	entries := make([]tools.LogEntry, count)
+	for i := range entries {
+		entries[i] = tools.LogEntry{
+			APIObject: pagerduty.APIObject{ID: fmt.Sprintf("R%08d", i), Type: "trigger_log_entry"},
+			CreatedAt: "2018-06-01T00:00:00Z",
+			Incident:  pagerduty.APIObject{ID: fmt.Sprintf("P%06d", i/10)},
+		}
+	}
+
+	return entries
+}
+
+const benchmarkRows = 5000
+
+func BenchmarkUpdateLogEntries(b *testing.B) {
+
+	db := postgrestest.OpenDB(b)
+	entries := benchmarkLogEntries(benchmarkRows)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		db.TruncateTable("log_entries")
+		for i := range entries {
+			_, err := db.UpdateLogEntries(entries[i])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBulkUpsertLogEntries(b *testing.B) {
+
+	db := postgrestest.OpenDB(b)
+	entries := benchmarkLogEntries(benchmarkRows)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		db.TruncateTable("log_entries")
+		result, err := db.BulkUpsertLogEntries(entries)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Inserted != benchmarkRows {
+			b.Fatalf("Expected %d inserted rows, got %+v", benchmarkRows, result)
+		}
+	}
+}
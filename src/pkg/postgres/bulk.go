@@ -0,0 +1,329 @@
+package postgres
+
+import (
+	"../tools"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// defaultBatchSize is used when tools.EnvironmentVariables.BatchSize isn't populated
+const defaultBatchSize = 1000
+
+// BulkResult is what a bulk upsert did. Rows the database rejected were skipped and are listed
+// in Rejected as *ConstraintError, they don't fail the rest of the slice.
+type BulkResult struct {
+	UpsertCounts
+	Rejected []error
+}
+
+func (db *DB) BulkUpsertEscalationPolicies(input []tools.EscalationsPolicy) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = escalationPolicyValues(input[i])
+	}
+
+	return db.bulkUpsert("escalation_policies", escalationPolicyColumns, upsertEscalationPolicies, rows)
+}
+
+func (db *DB) BulkUpsertUsers(input []tools.User) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = userValues(input[i])
+	}
+
+	return db.bulkUpsert("users", userColumns, upsertUsers, rows)
+}
+
+func (db *DB) BulkUpsertServices(input []tools.Service) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = serviceValues(input[i])
+	}
+
+	return db.bulkUpsert("services", serviceColumns, upsertServices, rows)
+}
+
+func (db *DB) BulkUpsertIncidents(input []tools.Incident) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = incidentValues(input[i])
+	}
+
+	return db.bulkUpsert("incidents", incidentColumns, upsertIncidents, rows)
+}
+
+func (db *DB) BulkUpsertLogEntries(input []tools.LogEntry) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = logEntryValues(input[i])
+	}
+
+	return db.bulkUpsert("log_entries", logEntryColumns, upsertLogEntries, rows)
+}
+
+func (db *DB) BulkUpsertAlerts(input []tools.Alert) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = alertValues(input[i])
+	}
+
+	return db.bulkUpsert("alerts", alertColumns, upsertAlerts, rows)
+}
+
+func (db *DB) BulkUpsertIncidentNotes(input []tools.IncidentNote) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = incidentNoteValues(input[i])
+	}
+
+	return db.bulkUpsert("incident_notes", incidentNoteColumns, upsertIncidentNotes, rows)
+}
+
+func (db *DB) BulkUpsertOnCallShifts(input []tools.OnCallShift) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = onCallShiftValues(input[i])
+	}
+
+	return db.bulkUpsert("oncall_shifts", onCallShiftColumns, upsertOnCallShifts, rows)
+}
+
+func (db *DB) BulkUpsertScheduleOverrides(input []tools.ScheduleOverride) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = scheduleOverrideValues(input[i])
+	}
+
+	return db.bulkUpsert("schedule_overrides", scheduleOverrideColumns, upsertScheduleOverrides, rows)
+}
+
+func (db *DB) BulkUpsertScheduleEntries(input []tools.ScheduleEntry) (BulkResult, error) {
+
+	rows := make([][]interface{}, len(input))
+	for i := range input {
+		rows[i] = scheduleEntryValues(input[i])
+	}
+
+	return db.bulkUpsert("schedule_entries", scheduleEntryColumns, upsertScheduleEntries, rows)
+}
+
+// bulkUpsert writes rows in batches of tools.EnvironmentVariables.BatchSize. Each batch is COPYed
+// into a temporary staging table and merged into table with a single statement, instead of one
+// round trip per row. A batch the database rejects with a constraint violation is written again
+// row by row with the single row statement, so only the offending rows are skipped.
+func (db *DB) bulkUpsert(table string, columns []string, singleRow string, rows [][]interface{}) (BulkResult, error) {
+
+	var result BulkResult
+
+	batchSize := tools.EnvironmentVariables.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		counts, err := db.copyBatch(table, columns, rows[start:end])
+
+		var constraintErr *ConstraintError
+		if errors.As(err, &constraintErr) {
+			fmt.Println("Batch rejected, writing it row by row:", err)
+			counts, err = db.upsertRows(table, singleRow, rows[start:end], &result.Rejected)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.Inserted += counts.Inserted
+		result.Updated += counts.Updated
+		result.Unchanged += counts.Unchanged
+	}
+
+	return result, nil
+}
+
+// copyBatch loads rows into the staging table and merges them into table inside a single
+// transaction, or a savepoint when the store is already bound to one
+func (db *DB) copyBatch(table string, columns []string, rows [][]interface{}) (UpsertCounts, error) {
+
+	var counts UpsertCounts
+	staging := table + "_staging"
+
+	err := db.inBatch(func(tx *sql.Tx) error {
+
+		// ON COMMIT DROP cleans up after a standalone batch, inside a longer transaction the
+		// table survives until commit and is emptied for every batch instead
+		_, err := tx.Exec(fmt.Sprintf("CREATE TEMP TABLE IF NOT EXISTS %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", staging, table))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("TRUNCATE " + staging)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.Prepare(pq.CopyIn(staging, columns...))
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			_, err = stmt.Exec(row...)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+
+		// Flush the buffered rows
+		_, err = stmt.Exec()
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+		err = stmt.Close()
+		if err != nil {
+			return err
+		}
+
+		merged, err := tx.Query(mergeStatement(table, staging, columns...))
+		if err != nil {
+			return err
+		}
+		defer merged.Close()
+
+		for merged.Next() {
+			var inserted bool
+			err = merged.Scan(&inserted)
+			if err != nil {
+				return err
+			}
+			if inserted {
+				counts.Inserted++
+			} else {
+				counts.Updated++
+			}
+		}
+		err = merged.Err()
+		if err != nil {
+			return err
+		}
+
+		// Merged rows that were already up to date aren't returned
+		counts.Unchanged = distinctKeys(rows) - counts.Inserted - counts.Updated
+
+		return nil
+	})
+
+	return counts, wrapError("bulk update "+table, table, err)
+}
+
+// upsertRows is the fallback for a rejected batch, rows rejected again are appended to rejected
+func (db *DB) upsertRows(table string, singleRow string, rows [][]interface{}, rejected *[]error) (UpsertCounts, error) {
+
+	var counts UpsertCounts
+
+	for _, row := range rows {
+		outcome, err := db.upsert(table, singleRow, row...)
+
+		var constraintErr *ConstraintError
+		if errors.As(err, &constraintErr) {
+			*rejected = append(*rejected, err)
+			continue
+		}
+		if err != nil {
+			return counts, err
+		}
+
+		counts.Add(outcome)
+	}
+
+	return counts, nil
+}
+
+// inBatch runs fn in its own transaction, or in a savepoint of the transaction the store is bound
+// to, so a failed batch is rolled back on its own
+func (db *DB) inBatch(fn func(tx *sql.Tx) error) error {
+
+	if db.tx != nil {
+		_, err := db.tx.Exec("SAVEPOINT batch")
+		if err != nil {
+			return err
+		}
+
+		err = fn(db.tx)
+		if err != nil {
+			if _, rollbackErr := db.tx.Exec("ROLLBACK TO SAVEPOINT batch"); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+
+		_, err = db.tx.Exec("RELEASE SAVEPOINT batch")
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mergeStatement builds the upsertStatement counterpart reading from a staging table. A key
+// staged twice would make ON CONFLICT touch the same row twice, so only one of them is kept.
+func mergeStatement(table string, staging string, columns ...string) string {
+
+	key := columns[0]
+	assignments := make([]string, 0, len(columns)-1)
+	stored := make([]string, 0, len(columns)-1)
+	excluded := make([]string, 0, len(columns)-1)
+
+	for _, column := range columns[1:] {
+		assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		stored = append(stored, "t."+column)
+		excluded = append(excluded, "EXCLUDED."+column)
+	}
+
+	return fmt.Sprintf(`
+	INSERT INTO %s AS t (%s)
+	SELECT DISTINCT ON (%s) %s FROM %s ORDER BY %s
+	ON CONFLICT (%s) DO UPDATE SET %s
+	WHERE (%s) IS DISTINCT FROM (%s)
+	RETURNING (xmax = 0)`,
+		table, strings.Join(columns, ", "), key, strings.Join(columns, ", "), staging, key, key,
+		strings.Join(assignments, ", "), strings.Join(stored, ", "), strings.Join(excluded, ", "))
+}
+
+// distinctKeys counts the rows that survive the DISTINCT ON of mergeStatement
+func distinctKeys(rows [][]interface{}) int {
+
+	keys := make(map[interface{}]bool, len(rows))
+	for _, row := range rows {
+		keys[row[0]] = true
+	}
+
+	return len(keys)
+}
@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeStatement(t *testing.T) {
+
+	var result = mergeStatement("users", "users_staging", "id", "name", "email")
+
+	var expected = []string{
+		"INSERT INTO users AS t (id, name, email)",
+		"SELECT DISTINCT ON (id) id, name, email FROM users_staging ORDER BY id",
+		"ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email",
+		"WHERE (t.name, t.email) IS DISTINCT FROM (EXCLUDED.name, EXCLUDED.email)",
+		"RETURNING (xmax = 0)",
+	}
+
+	for _, fragment := range expected {
+		if !strings.Contains(result, fragment) {
+			t.Errorf("Expected %q in statement: %s", fragment, result)
+		}
+	}
+}
+
+func TestDistinctKeys(t *testing.T) {
+
+	rows := [][]interface{}{{"P1", "a"}, {"P2", "b"}, {"P1", "c"}}
+
+	if count := distinctKeys(rows); count != 2 {
+		t.Errorf("Expected 2 distinct keys, got %d", count)
+	}
+}
@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// ConstraintError is a row rejected by the database, e.g. a duplicate key or a missing not null column.
+// The rest of the batch can still be written.
+type ConstraintError struct {
+	Table      string
+	Constraint string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%s: constraint %s violated: %v", e.Table, e.Constraint, e.Err)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// TransientError is a lost connection, serialization failure or deadlock, the statement can be retried
+type TransientError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("%s: transient database error: %v", e.Op, e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// wrapError classifies a database error returned while running op against table
+func wrapError(op string, table string, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	if err == driver.ErrBadConn {
+		return &TransientError{Op: op, Err: err}
+	}
+
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch pqErr.Code.Class() {
+	case "23": // integrity_constraint_violation
+		return &ConstraintError{Table: table, Constraint: pqErr.Constraint, Err: pqErr}
+	case "08", "40", "53", "57": // connection_exception, transaction_rollback, insufficient_resources, operator_intervention
+		return &TransientError{Op: op, Err: pqErr}
+	default:
+		return fmt.Errorf("%s: %w", op, err)
+	}
+}
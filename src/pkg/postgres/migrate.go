@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations are embedded in the binary, named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the pg_advisory_xact_lock key serialising concurrent migrators, e.g. several
+// Lambda instances starting at once
+const migrationLock = 7320158241
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single schema change, Down is empty when it can't be reverted
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied. Migrations recorded in the
+// database but unknown to this binary are reported with Unknown set.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Unknown   bool
+}
+
+// LoadMigrations returns the embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: file name must look like 0001_name.up.sql", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d: conflicting names %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration in version order and returns the ones it applied.
+// All of them run in a single transaction, a failing migration leaves the schema untouched.
+func (db *DB) MigrateUp() ([]Migration, error) {
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+
+	err = db.withMigrationLock(func(tx *sql.Tx) error {
+
+		done, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			_, err = tx.Exec(migration.Up)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now())",
+				migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, wrapError("migrate up", "schema_migrations", err)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns the ones it reverted
+func (db *DB) MigrateDown(steps int) ([]Migration, error) {
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+
+	err = db.withMigrationLock(func(tx *sql.Tx) error {
+
+		done, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s: can't be reverted, no down file", migration.Version, migration.Name)
+			}
+
+			_, err = tx.Exec(migration.Down)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			if err != nil {
+				return err
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, wrapError("migrate down", "schema_migrations", err)
+	}
+
+	return reverted, nil
+}
+
+// MigrationStatus lists every migration known to the binary or recorded in the database. It only
+// reads schema_migrations, without waiting for a running migration, and reports every migration
+// pending when the table doesn't exist yet.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return nil, wrapError("migration status", "schema_migrations", err)
+	}
+
+	done := make(map[int]MigrationStatus)
+	if exists {
+		done, err = appliedMigrations(db)
+		if err != nil {
+			return nil, wrapError("migration status", "schema_migrations", err)
+		}
+	}
+
+	var status []MigrationStatus
+
+	known := make(map[int]bool)
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		row, applied := done[migration.Version]
+		status = append(status, MigrationStatus{Version: migration.Version, Name: migration.Name,
+			Applied: applied, AppliedAt: row.AppliedAt})
+	}
+
+	for version, row := range done {
+		if !known[version] {
+			status = append(status, row)
+		}
+	}
+
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+
+	return status, nil
+}
+
+// withMigrationLock runs fn in a transaction holding the migration advisory lock, creating the
+// schema_migrations table first if needed
+func (db *DB) withMigrationLock(fn func(tx *sql.Tx) error) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLock)
+	if err == nil {
+		_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version int primary key,
+			name varchar not null,
+			applied_at timestamptz not null)`)
+	}
+	if err == nil {
+		err = fn(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// appliedMigrations reads schema_migrations keyed by version, from a migration transaction or the
+// database itself
+func appliedMigrations(q queryer) (map[int]MigrationStatus, error) {
+
+	rows, err := q.Query("SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]MigrationStatus)
+	for rows.Next() {
+		status := MigrationStatus{Applied: true, Unknown: true}
+		err = rows.Scan(&status.Version, &status.Name, &status.AppliedAt)
+		if err != nil {
+			return nil, err
+		}
+		done[status.Version] = status
+	}
+
+	return done, rows.Err()
+}
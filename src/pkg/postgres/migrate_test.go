@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+
+	files := fstest.MapFS{
+		"migrations/0002_notes.up.sql":     {Data: []byte("create table notes ();")},
+		"migrations/0001_initial.up.sql":   {Data: []byte("create table incidents ();")},
+		"migrations/0001_initial.down.sql": {Data: []byte("drop table incidents;")},
+	}
+
+	migrations, err := loadMigrations(files, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[0].Name != "initial" || migrations[0].Down != "drop table incidents;" {
+		t.Errorf("Unexpected first migration: %+v", migrations[0])
+	}
+	if migrations[1].Version != 2 || migrations[1].Down != "" {
+		t.Errorf("Unexpected second migration: %+v", migrations[1])
+	}
+}
+
+func TestLoadMigrationsRejectsBadFiles(t *testing.T) {
+
+	for name, files := range map[string]fstest.MapFS{
+		"bad name":   {"migrations/initial.sql": {Data: []byte("")}},
+		"missing up": {"migrations/0001_initial.down.sql": {Data: []byte("")}},
+		"name clash": {"migrations/0001_a.up.sql": {Data: []byte("")}, "migrations/0001_b.up.sql": {Data: []byte("")}},
+	} {
+		_, err := loadMigrations(files, "migrations")
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("Expected version %d, got %d_%s", i+1, migration.Version, migration.Name)
+		}
+		if migration.Down == "" {
+			t.Errorf("Migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+drop extension if exists tablefunc;
+drop table if exists sync_checkpoints;
+drop table if exists user_schedule;
+drop table if exists users;
+drop table if exists schedules;
+drop table if exists escalation_rule_schedules;
+drop table if exists escalation_rule_users;
+drop table if exists escalation_rules;
+drop table if exists escalation_policies;
+drop table if exists services;
+drop table if exists log_entries;
+drop table if exists incidents;
@@ -1,4 +1,6 @@
-create table incidents (
+-- Baseline schema. "if not exists" lets databases that had schema.sql applied by hand adopt it.
+
+create table if not exists incidents (
   id varchar primary key,
   incident_number int not null,
   created_at timestamptz not null,
@@ -11,7 +13,7 @@ create table incidents (
   trigger_type varchar not null
 );
 
-create table log_entries (
+create table if not exists log_entries (
   id varchar primary key,
   type varchar not null,
   created_at timestamptz not null,
@@ -24,54 +26,60 @@ create table log_entries (
   assigned_user_id varchar
 );
 
-create table services (
+create table if not exists services (
   id varchar primary key,
   name varchar not null,
   status varchar not null,
   type varchar not null
 );
 
-create table escalation_policies (
+create table if not exists escalation_policies (
   id varchar primary key,
   name varchar not null,
   num_loops int not null
 );
 
-create table escalation_rules (
+create table if not exists escalation_rules (
   id varchar primary key,
   escalation_policy_id varchar not null,
   escalation_delay_in_minutes int,
   level_index int
 );
 
-create table escalation_rule_users (
+create table if not exists escalation_rule_users (
   id varchar primary key,
   escalation_rule_id varchar not null,
   user_id varchar
 );
 
-create table escalation_rule_schedules (
+create table if not exists escalation_rule_schedules (
   id varchar primary key,
   escalation_rule_id varchar not null,
   schedule_id varchar
 );
 
-create table schedules (
+create table if not exists schedules (
   id varchar primary key,
   name varchar not null
 );
 
-create table users (
+create table if not exists users (
   id varchar primary key,
   name varchar not null,
   email varchar not null
 );
 
-create table user_schedule (
+create table if not exists user_schedule (
   id varchar primary key,
   user_id varchar,
   schedule_id varchar
 );
 
+create table if not exists sync_checkpoints (
+  entity varchar primary key,
+  window_end timestamptz not null,
+  updated_at timestamptz not null
+);
+
 -- Extension tablefunc enables crosstabs.
-create extension tablefunc;
+create extension if not exists tablefunc;
@@ -0,0 +1,10 @@
+create table sync_checkpoints (
+  entity varchar primary key,
+  window_end timestamptz not null,
+  updated_at timestamptz not null
+);
+
+insert into sync_checkpoints (entity, window_end, updated_at)
+select entity, high_water_mark, updated_at from sync_state;
+
+drop table sync_state;
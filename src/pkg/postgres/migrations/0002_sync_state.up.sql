@@ -0,0 +1,22 @@
+create table sync_state (
+  entity varchar primary key,
+  high_water_mark timestamptz not null,
+  last_success_at timestamptz,
+  last_error varchar,
+  run_id varchar,
+  updated_at timestamptz not null
+);
+
+insert into sync_state (entity, high_water_mark, last_success_at, updated_at)
+select entity, window_end, updated_at, updated_at from sync_checkpoints;
+
+-- Entities synced before checkpoints existed resume from their newest stored record
+insert into sync_state (entity, high_water_mark, updated_at)
+select 'incidents', max(created_at), now() from incidents having max(created_at) is not null
+on conflict (entity) do nothing;
+
+insert into sync_state (entity, high_water_mark, updated_at)
+select 'log_entries', max(created_at), now() from log_entries having max(created_at) is not null
+on conflict (entity) do nothing;
+
+drop table sync_checkpoints;
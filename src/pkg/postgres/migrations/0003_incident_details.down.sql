@@ -0,0 +1,16 @@
+alter table incidents
+  drop column title,
+  drop column description,
+  drop column status,
+  drop column urgency,
+  drop column priority_id,
+  drop column priority_name,
+  drop column last_status_change_at,
+  drop column resolved_at,
+  drop column acknowledger_ids,
+  drop column assignee_ids,
+  drop column team_ids,
+  drop column alert_count_triggered,
+  drop column alert_count_resolved,
+  drop column alert_count_all,
+  add column trigger_summary_description varchar;
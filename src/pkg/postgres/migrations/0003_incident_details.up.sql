@@ -0,0 +1,23 @@
+alter table incidents
+  add column title varchar,
+  add column description varchar,
+  add column status varchar,
+  add column urgency varchar,
+  add column priority_id varchar,
+  add column priority_name varchar,
+  add column last_status_change_at timestamptz,
+  add column resolved_at timestamptz,
+  add column acknowledger_ids varchar[],
+  add column assignee_ids varchar[],
+  add column team_ids varchar[],
+  add column alert_count_triggered int,
+  add column alert_count_resolved int,
+  add column alert_count_all int;
+
+-- trigger_summary_description used to receive the first trigger log entry URL, the incident's
+-- description is stored in description now
+alter table incidents drop column trigger_summary_description;
+
+-- Incidents synced before have none of the columns above. Rewind the cursor so the next runs fetch
+-- every incident again from PAGERDUTY_EPOCH.
+delete from sync_state where entity = 'incidents';
@@ -0,0 +1,7 @@
+alter table log_entries
+  drop column assignee_ids,
+  drop column notification_status,
+  drop column notification_address,
+  drop column channel_summary,
+  drop column channel_subject,
+  drop column event_details;
@@ -0,0 +1,12 @@
+alter table log_entries
+  add column assignee_ids varchar[],
+  add column notification_status varchar,
+  add column notification_address varchar,
+  add column channel_summary varchar,
+  add column channel_subject varchar,
+  add column event_details jsonb;
+
+-- user_id and assigned_user_id used to receive a team id and notification_type the log entry type.
+-- Clear them and rewind the cursor so the next runs fetch every log entry again from PAGERDUTY_EPOCH.
+update log_entries set user_id = null, assigned_user_id = null, notification_type = null;
+delete from sync_state where entity = 'log_entries';
@@ -0,0 +1 @@
+drop table oncall_shifts;
@@ -0,0 +1,11 @@
+create table oncall_shifts (
+  id varchar primary key,
+  user_id varchar not null,
+  schedule_id varchar,
+  escalation_policy_id varchar not null,
+  escalation_level int not null,
+  start_at timestamptz,
+  end_at timestamptz
+);
+
+create index oncall_shifts_user_id_start_at on oncall_shifts (user_id, start_at);
@@ -0,0 +1,4 @@
+drop table schedule_entries;
+drop table schedule_overrides;
+drop table schedule_layer_users;
+drop table schedule_layers;
@@ -0,0 +1,37 @@
+create table schedule_layers (
+  id varchar primary key,
+  schedule_id varchar not null,
+  name varchar,
+  start_at timestamptz not null,
+  end_at timestamptz,
+  rotation_virtual_start timestamptz,
+  rotation_turn_length_seconds int,
+  rendered_coverage_percentage numeric
+);
+
+create table schedule_layer_users (
+  id varchar primary key,
+  schedule_layer_id varchar not null,
+  user_id varchar not null,
+  position int not null
+);
+
+create table schedule_overrides (
+  id varchar primary key,
+  schedule_id varchar not null,
+  user_id varchar not null,
+  start_at timestamptz not null,
+  end_at timestamptz not null
+);
+
+create index schedule_overrides_schedule_id_start_at on schedule_overrides (schedule_id, start_at);
+
+create table schedule_entries (
+  id varchar primary key,
+  schedule_id varchar not null,
+  user_id varchar not null,
+  start_at timestamptz not null,
+  end_at timestamptz not null
+);
+
+create index schedule_entries_schedule_id_start_at on schedule_entries (schedule_id, start_at);
@@ -0,0 +1,6 @@
+alter table users drop column team_ids;
+alter table escalation_policies drop column team_ids;
+alter table services drop column team_ids;
+
+drop table team_members;
+drop table teams;
@@ -0,0 +1,18 @@
+create table teams (
+  id varchar primary key,
+  name varchar not null,
+  description varchar
+);
+
+create table team_members (
+  id varchar primary key,
+  team_id varchar not null,
+  user_id varchar not null,
+  role varchar
+);
+
+create index team_members_user_id on team_members (user_id);
+
+alter table services add column team_ids varchar[];
+alter table escalation_policies add column team_ids varchar[];
+alter table users add column team_ids varchar[];
@@ -0,0 +1,3 @@
+alter table incidents drop column alerts_synced_at;
+
+drop table alerts;
@@ -0,0 +1,19 @@
+create table alerts (
+  id varchar primary key,
+  incident_id varchar not null,
+  alert_key varchar,
+  severity varchar,
+  status varchar not null,
+  summary varchar,
+  service_id varchar,
+  integration_id varchar,
+  created_at timestamptz not null,
+  resolved_at timestamptz,
+  body jsonb
+);
+
+create index alerts_incident_id on alerts (incident_id);
+
+-- The incident's last_status_change_at when its alerts were last fetched, alerts of a resolved
+-- incident that hasn't changed since aren't fetched again
+alter table incidents add column alerts_synced_at timestamptz;
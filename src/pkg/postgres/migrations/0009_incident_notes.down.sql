@@ -0,0 +1,3 @@
+alter table incidents drop column notes_synced_at;
+
+drop table incident_notes;
@@ -0,0 +1,12 @@
+create table incident_notes (
+  id varchar primary key,
+  incident_id varchar not null,
+  user_id varchar,
+  content varchar not null,
+  created_at timestamptz not null
+);
+
+create index incident_notes_incident_id on incident_notes (incident_id);
+
+-- The incident's last_status_change_at when its notes were last fetched
+alter table incidents add column notes_synced_at timestamptz;
@@ -0,0 +1,5 @@
+drop index incidents_urgency;
+drop index incidents_priority_id;
+
+drop table urgencies;
+drop table priorities;
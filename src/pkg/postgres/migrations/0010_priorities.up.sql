@@ -0,0 +1,19 @@
+create table priorities (
+  id varchar primary key,
+  name varchar not null,
+  description varchar,
+  color varchar,
+  sort_order int not null
+);
+
+create table urgencies (
+  id varchar primary key,
+  description varchar not null
+);
+
+insert into urgencies (id, description) values
+  ('high', 'Notifies responders with high-urgency notification rules'),
+  ('low', 'Notifies responders with low-urgency notification rules');
+
+create index incidents_priority_id on incidents (priority_id);
+create index incidents_urgency on incidents (urgency);
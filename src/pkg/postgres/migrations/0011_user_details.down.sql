@@ -0,0 +1,9 @@
+drop table user_notification_rules;
+drop table user_contact_methods;
+
+alter table users
+  drop column deleted_at,
+  drop column invitation_sent,
+  drop column job_title,
+  drop column role,
+  drop column time_zone;
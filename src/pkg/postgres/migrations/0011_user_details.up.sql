@@ -0,0 +1,29 @@
+alter table users
+  add column time_zone varchar,
+  add column role varchar,
+  add column job_title varchar,
+  add column invitation_sent boolean not null default false,
+  add column deleted_at timestamptz;
+
+create table user_contact_methods (
+  id varchar primary key,
+  user_id varchar not null,
+  type varchar not null,
+  label varchar,
+  address varchar,
+  country_code int,
+  blacklisted boolean not null default false
+);
+
+create index user_contact_methods_user_id on user_contact_methods (user_id);
+
+create table user_notification_rules (
+  id varchar primary key,
+  user_id varchar not null,
+  contact_method_id varchar,
+  contact_method_type varchar,
+  urgency varchar not null,
+  start_delay_in_minutes int not null
+);
+
+create index user_notification_rules_user_id on user_notification_rules (user_id);
@@ -0,0 +1,20 @@
+drop table service_dependencies;
+drop table business_services;
+drop table service_integrations;
+
+alter table services
+  drop column support_hours_days_of_week,
+  drop column support_hours_end_time,
+  drop column support_hours_start_time,
+  drop column support_hours_time_zone,
+  drop column urgency_outside_support_hours,
+  drop column urgency_during_support_hours,
+  drop column urgency,
+  drop column urgency_rule_type,
+  drop column last_incident_at,
+  drop column created_at,
+  drop column alert_creation,
+  drop column acknowledgement_timeout,
+  drop column auto_resolve_timeout,
+  drop column escalation_policy_id,
+  drop column description;
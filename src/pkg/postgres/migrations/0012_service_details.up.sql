@@ -0,0 +1,47 @@
+alter table services
+  add column description varchar,
+  add column escalation_policy_id varchar,
+  add column auto_resolve_timeout int,
+  add column acknowledgement_timeout int,
+  add column alert_creation varchar,
+  add column created_at timestamptz,
+  add column last_incident_at timestamptz,
+  add column urgency_rule_type varchar,
+  add column urgency varchar,
+  add column urgency_during_support_hours varchar,
+  add column urgency_outside_support_hours varchar,
+  add column support_hours_time_zone varchar,
+  add column support_hours_start_time time,
+  add column support_hours_end_time time,
+  add column support_hours_days_of_week int[];
+
+create table service_integrations (
+  id varchar primary key,
+  service_id varchar not null,
+  name varchar,
+  type varchar not null,
+  vendor_id varchar,
+  vendor_name varchar,
+  created_at timestamptz
+);
+
+create index service_integrations_service_id on service_integrations (service_id);
+
+create table business_services (
+  id varchar primary key,
+  name varchar not null,
+  description varchar,
+  point_of_contact varchar,
+  team_id varchar
+);
+
+create table service_dependencies (
+  id varchar primary key,
+  supporting_service_id varchar not null,
+  supporting_service_type varchar not null,
+  dependent_service_id varchar not null,
+  dependent_service_type varchar not null
+);
+
+create index service_dependencies_supporting_service_id on service_dependencies (supporting_service_id);
+create index service_dependencies_dependent_service_id on service_dependencies (dependent_service_id);
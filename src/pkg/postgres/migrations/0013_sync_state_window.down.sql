@@ -0,0 +1 @@
+alter table sync_state drop column window_seconds;
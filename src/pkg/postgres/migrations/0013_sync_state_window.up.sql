@@ -0,0 +1,2 @@
+-- Window size a windowed transfer settled on after splitting windows too large to page through
+alter table sync_state add column window_seconds int;
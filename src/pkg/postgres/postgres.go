@@ -2,7 +2,10 @@ package postgres
 
 import (
 	"../tools"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"github.com/lib/pq"
 	"time"
@@ -16,207 +19,526 @@ type User struct {
 	Email string
 }
 
+// ReportingStore methods return a *ConstraintError for rows the database rejected,
+// a *TransientError for failures worth retrying and a plain wrapped error otherwise.
+// Update methods upsert a single row and report whether it was inserted, updated or unchanged,
+// BulkUpsert methods write a whole slice in batches and report the counts.
 type ReportingStore interface {
-	AllUsers() []*User
-	UpdateEscalationPolicies(tools.EscalationsPolicy)
-	UpdateEscalationRules(tools.EscalationsRule)
-	UpdateEscalationRuleUsers(tools.EscalationsRuleUser)
-	UpdateEscalationRuleSchedules(tools.EscalationsRuleSchedule)
-	UpdateUsers(tools.User)
-	UpdateServices(tools.Service)
-	UpdateSchedules(tools.Schedule)
-	UpdateUserSchedules(tools.UserSchedule)
-	UpdateIncidents(tools.Incident)
-	UpdateLogEntries(tools.LogEntry)
-	CalcLastIncidentRecordDate() time.Time
-	CalcLastLogEntryRecordDate() time.Time
-	TruncateTable(string)
-}
-
-// Implements a custome DB type, gives us an option to mock DB connections
+	AllUsers() ([]*User, error)
+	UpdateEscalationPolicies(tools.EscalationsPolicy) (UpsertOutcome, error)
+	UpdateEscalationRules(tools.EscalationsRule) (UpsertOutcome, error)
+	UpdateEscalationRuleUsers(tools.EscalationsRuleUser) (UpsertOutcome, error)
+	UpdateEscalationRuleSchedules(tools.EscalationsRuleSchedule) (UpsertOutcome, error)
+	UpdateUsers(tools.User) (UpsertOutcome, error)
+	UpdateUserContactMethods(tools.UserContactMethod) (UpsertOutcome, error)
+	UpdateUserNotificationRules(tools.UserNotificationRule) (UpsertOutcome, error)
+	UpdateTeams(tools.Team) (UpsertOutcome, error)
+	UpdateTeamMembers(tools.TeamMember) (UpsertOutcome, error)
+	UpdateServices(tools.Service) (UpsertOutcome, error)
+	UpdateServiceIntegrations(tools.ServiceIntegration) (UpsertOutcome, error)
+	UpdateBusinessServices(tools.BusinessService) (UpsertOutcome, error)
+	UpdateServiceDependencies(tools.ServiceDependency) (UpsertOutcome, error)
+	UpdatePriorities(tools.Priority) (UpsertOutcome, error)
+	UpdateSchedules(tools.Schedule) (UpsertOutcome, error)
+	UpdateUserSchedules(tools.UserSchedule) (UpsertOutcome, error)
+	UpdateScheduleLayers(tools.ScheduleLayer) (UpsertOutcome, error)
+	UpdateScheduleLayerUsers(tools.ScheduleLayerUser) (UpsertOutcome, error)
+	UpdateIncidents(tools.Incident) (UpsertOutcome, error)
+	UpdateLogEntries(tools.LogEntry) (UpsertOutcome, error)
+	UpdateOnCallShifts(tools.OnCallShift) (UpsertOutcome, error)
+	BulkUpsertEscalationPolicies([]tools.EscalationsPolicy) (BulkResult, error)
+	BulkUpsertUsers([]tools.User) (BulkResult, error)
+	BulkUpsertServices([]tools.Service) (BulkResult, error)
+	BulkUpsertIncidents([]tools.Incident) (BulkResult, error)
+	BulkUpsertLogEntries([]tools.LogEntry) (BulkResult, error)
+	BulkUpsertAlerts([]tools.Alert) (BulkResult, error)
+	BulkUpsertIncidentNotes([]tools.IncidentNote) (BulkResult, error)
+	BulkUpsertOnCallShifts([]tools.OnCallShift) (BulkResult, error)
+	BulkUpsertScheduleOverrides([]tools.ScheduleOverride) (BulkResult, error)
+	BulkUpsertScheduleEntries([]tools.ScheduleEntry) (BulkResult, error)
+	IncidentsSyncedAt(string, []string) (map[string]time.Time, error)
+	OpenIncidentIDs() ([]string, error)
+	ServiceIDs() ([]string, error)
+	LoggedIncidentIDs(time.Time, time.Time) ([]string, error)
+	AnnotatedIncidentIDs(time.Time, time.Time) ([]string, error)
+	SetIncidentsSyncedAt(string, []tools.Incident) error
+	GetCursor(string) (Cursor, bool, error)
+	SetCursor(Cursor) error
+	LockEntity(string) (func(), bool, error)
+	TruncateTable(string) error
+	DeleteStaleRows(string, []string) (int64, error)
+	MarkUsersDeleted([]string) (int64, error)
+	DeleteStaleScheduleRows(string, string, time.Time, []string) (int64, error)
+	InTransaction(func(ReportingStore) error) error
+}
+
+// Implements a custome DB type, gives us an option to mock DB connections.
+// tx is set on the copy handed out by InTransaction.
 type DB struct {
 	*sql.DB
+	tx *sql.Tx
 }
 
 // Open DB connection
-func DatabaseConnect(DataSourceName string) *DB {
+func DatabaseConnect(DataSourceName string) (*DB, error) {
 
 	db, err := sql.Open("postgres", DataSourceName)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("open database: %w", err)
 	}
 
 	err = db.Ping()
 	if err != nil {
-		panic(err)
+		return nil, wrapError("connect to database", "", err)
 	}
 
 	fmt.Println("Successfully connected!")
-	return &DB{db}
+	return &DB{DB: db}, nil
 
 }
 
-// Get all users from reporting database
+// Stored columns per table, the first one is the key
+var (
+	escalationPolicyColumns       = []string{"id", "name", "num_loops", "team_ids"}
+	escalationRuleColumns         = []string{"id", "escalation_policy_id", "escalation_delay_in_minutes", "level_index"}
+	escalationRuleUserColumns     = []string{"id", "escalation_rule_id", "user_id"}
+	escalationRuleScheduleColumns = []string{"id", "escalation_rule_id", "schedule_id"}
+	scheduleColumns               = []string{"id", "name"}
+	userScheduleColumns           = []string{"id", "user_id", "schedule_id"}
+	serviceColumns                = []string{"id", "name", "status", "type", "team_ids", "description",
+		"escalation_policy_id", "auto_resolve_timeout", "acknowledgement_timeout", "alert_creation", "created_at",
+		"last_incident_at", "urgency_rule_type", "urgency", "urgency_during_support_hours", "urgency_outside_support_hours",
+		"support_hours_time_zone", "support_hours_start_time", "support_hours_end_time", "support_hours_days_of_week"}
+	serviceIntegrationColumns = []string{"id", "service_id", "name", "type", "vendor_id", "vendor_name", "created_at"}
+	businessServiceColumns    = []string{"id", "name", "description", "point_of_contact", "team_id"}
+	serviceDependencyColumns  = []string{"id", "supporting_service_id", "supporting_service_type", "dependent_service_id",
+		"dependent_service_type"}
+	userColumns = []string{"id", "name", "email", "team_ids", "time_zone", "role", "job_title",
+		"invitation_sent", "deleted_at"}
+	userContactMethodColumns    = []string{"id", "user_id", "type", "label", "address", "country_code", "blacklisted"}
+	userNotificationRuleColumns = []string{"id", "user_id", "contact_method_id", "contact_method_type", "urgency",
+		"start_delay_in_minutes"}
+	teamColumns       = []string{"id", "name", "description"}
+	priorityColumns   = []string{"id", "name", "description", "color", "sort_order"}
+	teamMemberColumns = []string{"id", "team_id", "user_id", "role"}
+	incidentColumns   = []string{"id", "incident_number", "created_at", "html_url", "incident_key", "service_id",
+		"escalation_policy_id", "trigger_summary_subject", "trigger_type",
+		"title", "description", "status", "urgency", "priority_id", "priority_name", "last_status_change_at", "resolved_at",
+		"acknowledger_ids", "assignee_ids", "team_ids", "alert_count_triggered", "alert_count_resolved", "alert_count_all"}
+	logEntryColumns = []string{"id", "type", "created_at", "incident_id", "agent_type", "agent_id",
+		"channel_type", "user_id", "notification_type", "assigned_user_id",
+		"assignee_ids", "notification_status", "notification_address", "channel_summary", "channel_subject", "event_details"}
+	alertColumns = []string{"id", "incident_id", "alert_key", "severity", "status", "summary", "service_id",
+		"integration_id", "created_at", "resolved_at", "body"}
+	incidentNoteColumns  = []string{"id", "incident_id", "user_id", "content", "created_at"}
+	scheduleLayerColumns = []string{"id", "schedule_id", "name", "start_at", "end_at", "rotation_virtual_start",
+		"rotation_turn_length_seconds", "rendered_coverage_percentage"}
+	scheduleLayerUserColumns = []string{"id", "schedule_layer_id", "user_id", "position"}
+	scheduleOverrideColumns  = []string{"id", "schedule_id", "user_id", "start_at", "end_at"}
+	scheduleEntryColumns     = []string{"id", "schedule_id", "user_id", "start_at", "end_at"}
+	onCallShiftColumns       = []string{"id", "user_id", "schedule_id", "escalation_policy_id", "escalation_level", "start_at", "end_at"}
+)
 
-func (db *DB) UpdateEscalationPolicies(input tools.EscalationsPolicy) {
+// Upsert statements, keyed by the first column
+var (
+	upsertEscalationPolicies      = upsertStatement("escalation_policies", escalationPolicyColumns...)
+	upsertEscalationRules         = upsertStatement("escalation_rules", escalationRuleColumns...)
+	upsertEscalationRuleUsers     = upsertStatement("escalation_rule_users", escalationRuleUserColumns...)
+	upsertEscalationRuleSchedules = upsertStatement("escalation_rule_schedules", escalationRuleScheduleColumns...)
+	upsertSchedules               = upsertStatement("schedules", scheduleColumns...)
+	upsertUserSchedules           = upsertStatement("user_schedule", userScheduleColumns...)
+	upsertServices                = upsertStatement("services", serviceColumns...)
+	upsertServiceIntegrations     = upsertStatement("service_integrations", serviceIntegrationColumns...)
+	upsertBusinessServices        = upsertStatement("business_services", businessServiceColumns...)
+	upsertServiceDependencies     = upsertStatement("service_dependencies", serviceDependencyColumns...)
+	upsertUsers                   = upsertStatement("users", userColumns...)
+	upsertUserContactMethods      = upsertStatement("user_contact_methods", userContactMethodColumns...)
+	upsertUserNotificationRules   = upsertStatement("user_notification_rules", userNotificationRuleColumns...)
+	upsertIncidents               = upsertStatement("incidents", incidentColumns...)
+	upsertLogEntries              = upsertStatement("log_entries", logEntryColumns...)
+	upsertOnCallShifts            = upsertStatement("oncall_shifts", onCallShiftColumns...)
+	upsertAlerts                  = upsertStatement("alerts", alertColumns...)
+	upsertIncidentNotes           = upsertStatement("incident_notes", incidentNoteColumns...)
+	upsertTeams                   = upsertStatement("teams", teamColumns...)
+	upsertPriorities              = upsertStatement("priorities", priorityColumns...)
+	upsertTeamMembers             = upsertStatement("team_members", teamMemberColumns...)
+	upsertScheduleLayers          = upsertStatement("schedule_layers", scheduleLayerColumns...)
+	upsertScheduleLayerUsers      = upsertStatement("schedule_layer_users", scheduleLayerUserColumns...)
+	upsertScheduleOverrides       = upsertStatement("schedule_overrides", scheduleOverrideColumns...)
+	upsertScheduleEntries         = upsertStatement("schedule_entries", scheduleEntryColumns...)
+)
 
-	sqlStatement := `
-	INSERT INTO escalation_policies (Id, name, num_loops)
-	VALUES ($1, $2, $3)`
+func (db *DB) UpdateEscalationPolicies(input tools.EscalationsPolicy) (UpsertOutcome, error) {
 
-	_, err := db.Exec(sqlStatement, input.APIObject.ID, input.Name, input.NumLoops)
-	if err != nil {
-		panic(err)
-	}
+	return db.upsert("escalation_policies", upsertEscalationPolicies, escalationPolicyValues(input)...)
 }
 
-func (db *DB) UpdateEscalationRules(input tools.EscalationsRule) {
+func (db *DB) UpdateEscalationRules(input tools.EscalationsRule) (UpsertOutcome, error) {
 
-	sqlStatement := `
-	INSERT INTO escalation_rules (Id, escalation_policy_id, escalation_delay_in_minutes, level_index)
-	VALUES ($1, $2, $3, $4)`
+	return db.upsert("escalation_rules", upsertEscalationRules, escalationRuleValues(input)...)
+}
 
-	_, err := db.Exec(sqlStatement, input.ID, input.PolicyID, input.Delay, input.LevelIndex)
-	if err != nil {
-		panic(err)
-	}
+func (db *DB) UpdateEscalationRuleUsers(input tools.EscalationsRuleUser) (UpsertOutcome, error) {
+
+	return db.upsert("escalation_rule_users", upsertEscalationRuleUsers, escalationRuleUserValues(input)...)
 }
 
-func (db *DB) UpdateEscalationRuleUsers(input tools.EscalationsRuleUser) {
+func (db *DB) UpdateEscalationRuleSchedules(input tools.EscalationsRuleSchedule) (UpsertOutcome, error) {
 
-	sqlStatement := `
-	INSERT INTO escalation_rule_users (Id, escalation_rule_id, user_id)
-	VALUES ($1, $2, $3)`
+	return db.upsert("escalation_rule_schedules", upsertEscalationRuleSchedules, escalationRuleScheduleValues(input)...)
+}
 
-	_, err := db.Exec(sqlStatement, input.ID, input.RuleID, input.UserID)
-	if err != nil {
-		panic(err)
-	}
+func (db *DB) UpdateSchedules(input tools.Schedule) (UpsertOutcome, error) {
+
+	return db.upsert("schedules", upsertSchedules, scheduleValues(input)...)
 }
 
-func (db *DB) UpdateEscalationRuleSchedules(input tools.EscalationsRuleSchedule) {
+func (db *DB) UpdateUserSchedules(input tools.UserSchedule) (UpsertOutcome, error) {
 
-	sqlStatement := `
-	INSERT INTO escalation_rule_schedules (Id, escalation_rule_id, schedule_id)
-	VALUES ($1, $2, $3)`
+	return db.upsert("user_schedule", upsertUserSchedules, userScheduleValues(input)...)
+}
 
-	_, err := db.Exec(sqlStatement, input.ID, input.RuleID, input.ScheduleID)
-	if err != nil {
-		panic(err)
-	}
+func (db *DB) UpdateScheduleLayers(input tools.ScheduleLayer) (UpsertOutcome, error) {
+
+	return db.upsert("schedule_layers", upsertScheduleLayers, scheduleLayerValues(input)...)
 }
 
-func (db *DB) UpdateSchedules(input tools.Schedule) {
+func (db *DB) UpdateScheduleLayerUsers(input tools.ScheduleLayerUser) (UpsertOutcome, error) {
 
-	sqlStatement := `
-	INSERT INTO schedules (Id, name)
-	VALUES ($1, $2)`
+	return db.upsert("schedule_layer_users", upsertScheduleLayerUsers, scheduleLayerUserValues(input)...)
+}
 
-	_, err := db.Exec(sqlStatement, input.APIObject.ID, input.Name)
-	if err != nil {
-		panic(err)
-	}
+func (db *DB) UpdateServices(input tools.Service) (UpsertOutcome, error) {
+
+	return db.upsert("services", upsertServices, serviceValues(input)...)
 }
 
-func (db *DB) UpdateUserSchedules(input tools.UserSchedule) {
+func (db *DB) UpdateServiceIntegrations(input tools.ServiceIntegration) (UpsertOutcome, error) {
 
-	sqlStatement := `
-	INSERT INTO user_schedule (Id, user_id, schedule_id)
-	VALUES ($1, $2, $3)`
+	return db.upsert("service_integrations", upsertServiceIntegrations, serviceIntegrationValues(input)...)
+}
 
-	_, err := db.Exec(sqlStatement, input.ID, input.UserID, input.ScheduleID)
-	if err != nil {
-		panic(err)
-	}
+func (db *DB) UpdateBusinessServices(input tools.BusinessService) (UpsertOutcome, error) {
+
+	return db.upsert("business_services", upsertBusinessServices, businessServiceValues(input)...)
 }
 
-func (db *DB) UpdateServices(input tools.Service) {
+func (db *DB) UpdateServiceDependencies(input tools.ServiceDependency) (UpsertOutcome, error) {
 
-	sqlStatement := `
-	INSERT INTO services (Id, name, status, type)
-	VALUES ($1, $2, $3, $4)`
+	return db.upsert("service_dependencies", upsertServiceDependencies, serviceDependencyValues(input)...)
+}
 
-	_, err := db.Exec(sqlStatement, input.APIObject.ID, input.Name, input.Status, input.APIObject.Type)
-	if err != nil {
-		panic(err)
+func (db *DB) UpdateUsers(input tools.User) (UpsertOutcome, error) {
+
+	return db.upsert("users", upsertUsers, userValues(input)...)
+}
+
+func (db *DB) UpdateUserContactMethods(input tools.UserContactMethod) (UpsertOutcome, error) {
+
+	return db.upsert("user_contact_methods", upsertUserContactMethods, userContactMethodValues(input)...)
+}
+
+func (db *DB) UpdateUserNotificationRules(input tools.UserNotificationRule) (UpsertOutcome, error) {
+
+	return db.upsert("user_notification_rules", upsertUserNotificationRules, userNotificationRuleValues(input)...)
+}
+
+func (db *DB) UpdateTeams(input tools.Team) (UpsertOutcome, error) {
+
+	return db.upsert("teams", upsertTeams, teamValues(input)...)
+}
+
+func (db *DB) UpdatePriorities(input tools.Priority) (UpsertOutcome, error) {
+
+	return db.upsert("priorities", upsertPriorities, priorityValues(input)...)
+}
+
+func (db *DB) UpdateTeamMembers(input tools.TeamMember) (UpsertOutcome, error) {
+
+	return db.upsert("team_members", upsertTeamMembers, teamMemberValues(input)...)
+}
+
+func (db *DB) UpdateIncidents(input tools.Incident) (UpsertOutcome, error) {
+
+	return db.upsert("incidents", upsertIncidents, incidentValues(input)...)
+}
+
+func (db *DB) UpdateLogEntries(input tools.LogEntry) (UpsertOutcome, error) {
+
+	return db.upsert("log_entries", upsertLogEntries, logEntryValues(input)...)
+}
+
+func (db *DB) UpdateOnCallShifts(input tools.OnCallShift) (UpsertOutcome, error) {
+
+	return db.upsert("oncall_shifts", upsertOnCallShifts, onCallShiftValues(input)...)
+}
+
+// Column values in the order of the column lists above, shared by the single row and bulk paths
+
+func escalationPolicyValues(input tools.EscalationsPolicy) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name, input.NumLoops, pq.Array(input.TeamIDs)}
+}
+
+func escalationRuleValues(input tools.EscalationsRule) []interface{} {
+	return []interface{}{input.ID, input.PolicyID, input.Delay, input.LevelIndex}
+}
+
+func escalationRuleUserValues(input tools.EscalationsRuleUser) []interface{} {
+	return []interface{}{input.ID, input.RuleID, input.UserID}
+}
+
+func escalationRuleScheduleValues(input tools.EscalationsRuleSchedule) []interface{} {
+	return []interface{}{input.ID, input.RuleID, input.ScheduleID}
+}
+
+func scheduleValues(input tools.Schedule) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name}
+}
+
+func userScheduleValues(input tools.UserSchedule) []interface{} {
+	return []interface{}{input.ID, input.UserID, input.ScheduleID}
+}
+
+func scheduleLayerValues(input tools.ScheduleLayer) []interface{} {
+	return []interface{}{input.ID, input.ScheduleID, input.Name, input.Start, nullString(input.End),
+		nullString(input.RotationVirtualStart), input.RotationTurnLengthSeconds, input.RenderedCoveragePercentage}
+}
+
+func scheduleLayerUserValues(input tools.ScheduleLayerUser) []interface{} {
+	return []interface{}{input.ID, input.LayerID, input.UserID, input.Position}
+}
+
+func scheduleOverrideValues(input tools.ScheduleOverride) []interface{} {
+	return []interface{}{input.ID, input.ScheduleID, input.UserID, input.Start, input.End}
+}
+
+func scheduleEntryValues(input tools.ScheduleEntry) []interface{} {
+	return []interface{}{input.ID, input.ScheduleID, input.UserID, input.Start, input.End}
+}
+
+func serviceValues(input tools.Service) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name, input.Status, input.APIObject.Type, pq.Array(input.TeamIDs),
+		nullString(input.Description), nullString(input.EscalationPolicyID), nullUint(input.AutoResolveTimeout),
+		nullUint(input.AcknowledgementTimeout), nullString(input.AlertCreation), nullString(input.CreatedAt),
+		nullString(input.LastIncidentTimestamp), nullString(input.UrgencyRuleType), nullString(input.Urgency),
+		nullString(input.UrgencyDuringSupportHours), nullString(input.UrgencyOutsideSupportHours),
+		nullString(input.SupportHoursTimeZone), nullString(input.SupportHoursStartTime), nullString(input.SupportHoursEndTime),
+		pq.Array(input.SupportHoursDaysOfWeek)}
+}
+
+func serviceIntegrationValues(input tools.ServiceIntegration) []interface{} {
+	return []interface{}{input.ID, input.ServiceID, nullString(input.Name), input.Type, nullString(input.VendorID),
+		nullString(input.VendorName), nullString(input.CreatedAt)}
+}
+
+func businessServiceValues(input tools.BusinessService) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name, nullString(input.Description), nullString(input.PointOfContact),
+		nullString(input.TeamID)}
+}
+
+func serviceDependencyValues(input tools.ServiceDependency) []interface{} {
+	return []interface{}{input.ID, input.SupportingServiceID, input.SupportingServiceType, input.DependentServiceID,
+		input.DependentServiceType}
+}
+
+// userValues clears deleted_at, a user PagerDuty still lists isn't deleted
+func userValues(input tools.User) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name, input.Email, pq.Array(input.TeamIDs), nullString(input.TimeZone),
+		nullString(input.Role), nullString(input.JobTitle), input.InvitationSent, nil}
+}
+
+func userContactMethodValues(input tools.UserContactMethod) []interface{} {
+	return []interface{}{input.ID, input.UserID, input.Type, nullString(input.Label), nullString(input.Address),
+		input.CountryCode, input.Blacklisted}
+}
+
+func userNotificationRuleValues(input tools.UserNotificationRule) []interface{} {
+	return []interface{}{input.ID, input.UserID, nullString(input.ContactMethodID), nullString(input.ContactMethodType),
+		input.Urgency, input.StartDelayInMinutes}
+}
+
+func teamValues(input tools.Team) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name, nullString(input.Description)}
+}
+
+func priorityValues(input tools.Priority) []interface{} {
+	return []interface{}{input.APIObject.ID, input.Name, nullString(input.Description), nullString(input.Color), input.Order}
+}
+
+func teamMemberValues(input tools.TeamMember) []interface{} {
+	return []interface{}{input.ID, input.TeamID, input.UserID, nullString(input.Role)}
+}
+
+func incidentValues(input tools.Incident) []interface{} {
+
+	var priorityID, priorityName string
+	if input.Priority != nil {
+		priorityID, priorityName = input.Priority.ID, input.Priority.Name
 	}
+
+	// The API has no resolution time, a resolved incident last changed status when it was resolved
+	var resolvedAt string
+	if input.Status == "resolved" {
+		resolvedAt = input.LastStatusChangeAt
+	}
+
+	acknowledgerIDs := []string{}
+	for _, acknowledgement := range input.Acknowledgements {
+		acknowledgerIDs = append(acknowledgerIDs, acknowledgement.Acknowledger.ID)
+	}
+	assigneeIDs := []string{}
+	for _, assignment := range input.Assignments {
+		assigneeIDs = append(assigneeIDs, assignment.Assignee.ID)
+	}
+	teamIDs := []string{}
+	for _, team := range input.Teams {
+		teamIDs = append(teamIDs, team.ID)
+	}
+
+	return []interface{}{input.APIObject.ID, input.IncidentNumber, input.CreatedAt, input.APIObject.HTMLURL,
+		input.IncidentKey, input.Service.ID, input.EscalationPolicy.ID, input.FirstTriggerLogEntry.Summary,
+		input.FirstTriggerLogEntry.Type,
+		input.Title, input.Description, input.Status, input.Urgency, nullString(priorityID), nullString(priorityName),
+		nullString(input.LastStatusChangeAt), nullString(resolvedAt),
+		pq.Array(acknowledgerIDs), pq.Array(assigneeIDs), pq.Array(teamIDs),
+		input.AlertCounts.Triggered, input.AlertCounts.Resolved, input.AlertCounts.All}
 }
 
-func (db *DB) UpdateUsers(input tools.User) {
+func alertValues(input tools.Alert) []interface{} {
 
-	sqlStatement := `
-	INSERT INTO users (Id, name, email)
-	VALUES ($1, $2, $3)`
+	var body interface{}
+	if len(input.Body) > 0 {
+		if encoded, err := json.Marshal(input.Body); err == nil {
+			body = string(encoded)
+		}
+	}
 
-	_, err := db.Exec(sqlStatement, input.APIObject.ID, input.Name, input.Email)
-	if err != nil {
-		panic(err)
+	return []interface{}{input.APIObject.ID, input.Incident.ID, nullString(input.AlertKey), nullString(input.Severity),
+		input.Status, nullString(input.Summary), nullString(input.Service.ID), nullString(input.Integration.ID),
+		input.CreatedAt, nullString(input.ResolvedAt), body}
+}
+
+func incidentNoteValues(input tools.IncidentNote) []interface{} {
+	return []interface{}{input.ID, input.IncidentID, nullString(input.UserID), input.Content, input.CreatedAt}
+}
+
+// Permanent on-calls, outside of any schedule, have neither schedule nor start and end
+func onCallShiftValues(input tools.OnCallShift) []interface{} {
+	return []interface{}{input.ID, input.UserID, nullString(input.ScheduleID), input.EscalationPolicyID, input.EscalationLevel,
+		nullString(input.Start), nullString(input.End)}
+}
+
+// nullUint stores a timeout the API left unset, or disabled with null, as NULL
+func nullUint(value *uint) interface{} {
+
+	if value == nil {
+		return nil
 	}
+
+	return int64(*value)
 }
 
-func (db *DB) UpdateIncidents(input tools.Incident) {
+// nullString stores an empty string as NULL, e.g. a timestamp the API left out
+func nullString(value string) interface{} {
 
-	sqlStatement := `
-	INSERT INTO incidents (Id, incident_number, created_at, html_url, incident_key, service_id,
-		escalation_policy_id, trigger_summary_subject, trigger_summary_description, trigger_type)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	if value == "" {
+		return nil
+	}
 
-	_, err := db.Exec(sqlStatement, input.APIObject.ID, input.IncidentNumber, input.CreatedAt, input.APIObject.HTMLURL,
-		input.IncidentKey, input.Service.ID, input.EscalationPolicy.ID, input.FirstTriggerLogEntry.Summary,
-		input.FirstTriggerLogEntry.Self, input.FirstTriggerLogEntry.Type)
+	return value
+}
+
+func logEntryValues(input tools.LogEntry) []interface{} {
+
+	// assign log entries may name several assignees, assigned_user_id keeps the first like pd2pg did
+	var assignedUserID string
+	assigneeIDs := []string{}
+	for _, assignee := range input.Assignees {
+		assigneeIDs = append(assigneeIDs, assignee.ID)
+	}
+	if len(assigneeIDs) > 0 {
+		assignedUserID = assigneeIDs[0]
+	}
+
+	var eventDetails interface{}
+	if len(input.EventDetails) > 0 {
+		if details, err := json.Marshal(input.EventDetails); err == nil {
+			eventDetails = string(details)
+		}
+	}
+
+	return []interface{}{input.APIObject.ID, input.APIObject.Type, input.CreatedAt, input.Incident.ID,
+		nullString(input.Agent.Type), nullString(input.Agent.ID), nullString(input.Channel.Type), nullString(input.User.ID),
+		nullString(input.Notification.Type), nullString(assignedUserID),
+		pq.Array(assigneeIDs), nullString(input.Notification.Status), nullString(input.Notification.Address),
+		nullString(input.Channel.Summary), nullString(input.Channel.Subject), eventDetails}
+}
+
+// DeleteStaleRows removes rows whose id is not in ids, i.e. entities deleted in PagerDuty since the last refresh
+func (db *DB) DeleteStaleRows(TableName string, ids []string) (int64, error) {
 
+	sqlStatement := fmt.Sprintf("DELETE FROM %v WHERE NOT (id = ANY($1))", pq.QuoteIdentifier(TableName))
+	res, err := db.Exec(sqlStatement, pq.Array(ids))
 	if err != nil {
-		fmt.Println(err)
-		// panic(err)
+		return 0, wrapError("delete stale "+TableName, TableName, err)
 	}
+
+	count, err := res.RowsAffected()
+
+	return count, wrapError("delete stale "+TableName, TableName, err)
 }
 
-func (db *DB) UpdateLogEntries(input tools.LogEntry) {
+// MarkUsersDeleted sets deleted_at on users whose id is not in ids. Their rows are kept so incidents,
+// log entries and shifts still resolve the user.
+func (db *DB) MarkUsersDeleted(ids []string) (int64, error) {
 
-	sqlStatement := `
-	INSERT INTO log_entries (Id, type, created_at, incident_id, agent_type, agent_id,
-		channel_type, user_id, notification_type, assigned_user_id)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
-
-	// handle a case when log entry has no team assigned
-	var assigned_user_id string
-	var user_id string
-
-	if len(input.Teams) == 0 {
-		assigned_user_id, user_id = "", ""
-	} else {
-		assigned_user_id, user_id = input.Teams[0].ID, input.Teams[0].ID
+	res, err := db.Exec("UPDATE users SET deleted_at = now() WHERE deleted_at IS NULL AND NOT (id = ANY($1))", pq.Array(ids))
+	if err != nil {
+		return 0, wrapError("mark deleted users", "users", err)
 	}
 
-	_, err := db.Exec(sqlStatement, input.APIObject.ID, input.APIObject.Type, input.CreatedAt, input.Incident.ID,
-		input.Agent.Type, input.Agent.ID, input.Channel.Type, user_id,
-		input.APIObject.Type, assigned_user_id)
+	count, err := res.RowsAffected()
+
+	return count, wrapError("mark deleted users", "users", err)
+}
+
+// DeleteStaleScheduleRows removes rows of a schedule starting after since whose id is not in ids, i.e.
+// overrides and entries changed or deleted within the rendered horizon. Earlier rows are history and kept.
+func (db *DB) DeleteStaleScheduleRows(TableName string, scheduleID string, since time.Time, ids []string) (int64, error) {
 
+	sqlStatement := fmt.Sprintf("DELETE FROM %v WHERE schedule_id = $1 AND start_at > $2 AND NOT (id = ANY($3))",
+		pq.QuoteIdentifier(TableName))
+	res, err := db.Exec(sqlStatement, scheduleID, since, pq.Array(ids))
 	if err != nil {
-		fmt.Println(err)
+		return 0, wrapError("delete stale "+TableName, TableName, err)
 	}
+
+	count, err := res.RowsAffected()
+
+	return count, wrapError("delete stale "+TableName, TableName, err)
 }
 
-func (db *DB) TruncateTable(TableName string) {
+func (db *DB) TruncateTable(TableName string) error {
 
-	TableName = pq.QuoteIdentifier(TableName)
-	sqlStatement := fmt.Sprintf("TRUNCATE %v;", TableName)
+	quotedTableName := pq.QuoteIdentifier(TableName)
+	sqlStatement := fmt.Sprintf("TRUNCATE %v;", quotedTableName)
 	res, err := db.Exec(sqlStatement)
 	if err != nil {
-		panic(err)
+		return wrapError("truncate "+TableName, TableName, err)
 	}
 	count, err := res.RowsAffected()
 	if err != nil {
-		panic(err)
+		return wrapError("truncate "+TableName, TableName, err)
 	}
 	fmt.Println("Truncate complete: ", count)
 
+	return nil
 }
 
-func (db *DB) AllUsers() []*User {
-	rows, err := db.Query("SELECT * from users")
+func (db *DB) AllUsers() ([]*User, error) {
+	rows, err := db.Query("SELECT id, name, email from users")
 	if err != nil {
-		panic(err)
+		return nil, wrapError("select users", "users", err)
 	}
 
 	defer rows.Close()
@@ -229,67 +551,205 @@ func (db *DB) AllUsers() []*User {
 		// Validate that fetched row contains valid user entry
 		err := rows.Scan(&us.Id, &us.Name, &us.Email)
 		if err != nil {
-			panic(err)
+			return nil, wrapError("scan users", "users", err)
 		}
 
 		fmt.Println(us)
 		sliceOfUsers = append(sliceOfUsers, us)
 	}
 
-	return sliceOfUsers
+	return sliceOfUsers, wrapError("select users", "users", rows.Err())
 
 }
 
-func (db *DB) CalcLastIncidentRecordDate() time.Time {
-	/*
-		Calculate the point from which we should resume incremental
-		updates. Allow a bit of overlap to ensure we don't miss anything.
-	*/
-	var date string
-	var LastRecordedIncidentDate time.Time
+// Cursor is the persisted sync position of a windowed entity. Everything created before
+// HighWaterMark has been transferred, LastError is empty after a successful window. Window is the
+// size the next window starts at, zero for INCREMENTAL_WINDOW.
+type Cursor struct {
+	Entity        string
+	HighWaterMark time.Time
+	LastSuccessAt time.Time
+	LastError     string
+	RunID         string
+	Window        time.Duration
+}
 
-	sqlStatement := `SELECT created_at FROM public.incidents ORDER BY 1 DESC LIMIT 1`
-	row := db.QueryRow(sqlStatement)
-	switch err := row.Scan(&date); err {
-	case sql.ErrNoRows:
-		fmt.Println("No rows were returned, falling back to default start date:", tools.EnvironmentVariables.PagerDutyEpoch)
-		LastRecordedIncidentDate = tools.EnvironmentVariables.PagerDutyEpoch
-	case nil:
-		fmt.Println(date)
-		LastRecordedIncidentDate, err = time.Parse(time.RFC3339, date)
-	default:
-		panic(err)
+// Incident columns recording the last_status_change_at an incident's alerts or notes were fetched at
+const (
+	AlertsSyncedAt = "alerts_synced_at"
+	NotesSyncedAt  = "notes_synced_at"
+)
+
+// OpenIncidentIDs lists the incidents stored as triggered or acknowledged
+func (db *DB) OpenIncidentIDs() ([]string, error) {
+
+	return db.selectIDs("select open incidents", "incidents",
+		"SELECT id FROM incidents WHERE status IN ('triggered', 'acknowledged')")
+}
+
+// LoggedIncidentIDs lists the incidents with log entries created in [since, until)
+func (db *DB) LoggedIncidentIDs(since time.Time, until time.Time) ([]string, error) {
+
+	return db.selectIDs("select logged incidents", "log_entries",
+		"SELECT DISTINCT incident_id FROM log_entries WHERE created_at >= $1 AND created_at < $2 AND incident_id IS NOT NULL",
+		since, until)
+}
+
+// AnnotatedIncidentIDs lists the incidents with notes added in [since, until)
+func (db *DB) AnnotatedIncidentIDs(since time.Time, until time.Time) ([]string, error) {
+
+	return db.selectIDs("select annotated incidents", "log_entries",
+		"SELECT DISTINCT incident_id FROM log_entries WHERE type = 'annotate_log_entry' AND created_at >= $1 AND created_at < $2 AND incident_id IS NOT NULL",
+		since, until)
+}
+
+// ServiceIDs lists the stored technical services
+func (db *DB) ServiceIDs() ([]string, error) {
+
+	return db.selectIDs("select services", "services", "SELECT id FROM services")
+}
+
+func (db *DB) selectIDs(operation string, table string, query string, args ...interface{}) ([]string, error) {
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, wrapError(operation, table, err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, wrapError(operation, table, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, wrapError(operation, table, rows.Err())
+}
+
+// IncidentsSyncedAt reads column, AlertsSyncedAt or NotesSyncedAt, for each incident. Incidents
+// never synced are left out.
+func (db *DB) IncidentsSyncedAt(column string, incidentIDs []string) (map[string]time.Time, error) {
+
+	sqlStatement := fmt.Sprintf("SELECT id, %[1]s FROM incidents WHERE id = ANY($1) AND %[1]s IS NOT NULL",
+		pq.QuoteIdentifier(column))
+	rows, err := db.Query(sqlStatement, pq.Array(incidentIDs))
+	if err != nil {
+		return nil, wrapError("select "+column, "incidents", err)
+	}
+	defer rows.Close()
+
+	syncedAt := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var at time.Time
+		err = rows.Scan(&id, &at)
+		if err != nil {
+			return nil, wrapError("select "+column, "incidents", err)
+		}
+		syncedAt[id] = at
 	}
 
-	LastRecordedIncidentDate = LastRecordedIncidentDate.Add(time.Duration(-tools.EnvironmentVariables.IncrementalBuffer) * time.Second)
+	return syncedAt, wrapError("select "+column, "incidents", rows.Err())
+}
 
-	return LastRecordedIncidentDate
+// SetIncidentsSyncedAt sets column to the current last_status_change_at of incidents
+func (db *DB) SetIncidentsSyncedAt(column string, incidents []tools.Incident) error {
 
+	ids := make([]string, len(incidents))
+	changedAt := make([]string, len(incidents))
+	for i := range incidents {
+		ids[i] = incidents[i].APIObject.ID
+		changedAt[i] = incidents[i].LastStatusChangeAt
+	}
+
+	sqlStatement := fmt.Sprintf(`UPDATE incidents SET %s = NULLIF(synced.at, '')::timestamptz
+	FROM unnest($1::varchar[], $2::varchar[]) AS synced (id, at)
+	WHERE incidents.id = synced.id`, pq.QuoteIdentifier(column))
+	_, err := db.Exec(sqlStatement, pq.Array(ids), pq.Array(changedAt))
+
+	return wrapError("update "+column, "incidents", err)
 }
 
-func (db *DB) CalcLastLogEntryRecordDate() time.Time {
-	/*
-		Calculate the point from which we should resume incremental
-		updates. Allow a bit of overlap to ensure we don't miss anything.
-	*/
-	var date string
-	var LastRecordedLogEntryDate time.Time
+// GetCursor returns the sync position of entity, false when it has never been synced
+func (db *DB) GetCursor(entity string) (Cursor, bool, error) {
+
+	cursor := Cursor{Entity: entity}
+	var lastSuccessAt pq.NullTime
+	var lastError, runID sql.NullString
+	var windowSeconds sql.NullInt64
 
-	sqlStatement := `SELECT created_at FROM public.log_entries ORDER BY 1 DESC LIMIT 1`
-	row := db.QueryRow(sqlStatement)
-	switch err := row.Scan(&date); err {
+	sqlStatement := `SELECT high_water_mark, last_success_at, last_error, run_id, window_seconds FROM sync_state WHERE entity = $1`
+	row := db.QueryRow(sqlStatement, entity)
+	switch err := row.Scan(&cursor.HighWaterMark, &lastSuccessAt, &lastError, &runID, &windowSeconds); err {
 	case sql.ErrNoRows:
-		fmt.Println("No rows were returned, falling back to default start date:", tools.EnvironmentVariables.PagerDutyEpoch)
-		LastRecordedLogEntryDate = tools.EnvironmentVariables.PagerDutyEpoch
+		return cursor, false, nil
 	case nil:
-		fmt.Println(date)
-		LastRecordedLogEntryDate, err = time.Parse(time.RFC3339, date)
+		cursor.LastSuccessAt = lastSuccessAt.Time
+		cursor.LastError = lastError.String
+		cursor.RunID = runID.String
+		cursor.Window = time.Duration(windowSeconds.Int64) * time.Second
+		return cursor, true, nil
 	default:
-		panic(err)
+		return cursor, false, wrapError("select sync state", "sync_state", err)
+	}
+}
+
+// SetCursor stores the sync position of cursor.Entity, a zero LastSuccessAt keeps the stored one
+func (db *DB) SetCursor(cursor Cursor) error {
+
+	var lastSuccessAt pq.NullTime
+	if !cursor.LastSuccessAt.IsZero() {
+		lastSuccessAt = pq.NullTime{Time: cursor.LastSuccessAt, Valid: true}
 	}
 
-	LastRecordedLogEntryDate = LastRecordedLogEntryDate.Add(time.Duration(-tools.EnvironmentVariables.IncrementalBuffer) * time.Second)
+	sqlStatement := `
+	INSERT INTO sync_state (entity, high_water_mark, last_success_at, last_error, run_id, window_seconds, updated_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), now())
+	ON CONFLICT (entity) DO UPDATE SET
+		high_water_mark = EXCLUDED.high_water_mark,
+		last_success_at = COALESCE(EXCLUDED.last_success_at, sync_state.last_success_at),
+		last_error = EXCLUDED.last_error,
+		run_id = EXCLUDED.run_id,
+		window_seconds = COALESCE(EXCLUDED.window_seconds, sync_state.window_seconds),
+		updated_at = EXCLUDED.updated_at`
+
+	_, err := db.Exec(sqlStatement, cursor.Entity, cursor.HighWaterMark, lastSuccessAt, cursor.LastError, cursor.RunID,
+		int64(cursor.Window/time.Second))
+
+	return wrapError("save sync state", "sync_state", err)
+}
+
+// LockEntity takes the session advisory lock of entity so two invocations never transfer it at
+// once, locked is false when another one holds it. The lock lives on a connection of its own until
+// unlock is called.
+func (db *DB) LockEntity(entity string) (unlock func(), locked bool, err error) {
 
-	return LastRecordedLogEntryDate
+	ctx := context.Background()
+
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, wrapError("lock "+entity, "sync_state", err)
+	}
+
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", entity).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, wrapError("lock "+entity, "sync_state", err)
+	}
+
+	unlock = func() {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", entity)
+		if err != nil {
+			// Closing the session is the only other way to release the lock
+			fmt.Println("Failed to unlock", entity, "transfer:", err)
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
 
+	return unlock, true, nil
 }
@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"../tools"
+	"github.com/PagerDuty/go-pagerduty"
+	"github.com/lib/pq"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpsertStatement(t *testing.T) {
+
+	var result = upsertStatement("users", "id", "name", "email")
+
+	var expected = []string{
+		"INSERT INTO users AS t (id, name, email)",
+		"VALUES ($1, $2, $3)",
+		"ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email",
+		"WHERE (t.name, t.email) IS DISTINCT FROM (EXCLUDED.name, EXCLUDED.email)",
+		"RETURNING (xmax = 0)",
+	}
+
+	for _, fragment := range expected {
+		if !strings.Contains(result, fragment) {
+			t.Errorf("Expected %q in statement: %s", fragment, result)
+		}
+	}
+}
+
+func TestUpsertCounts(t *testing.T) {
+
+	var counts UpsertCounts
+
+	for _, outcome := range []UpsertOutcome{Inserted, Inserted, Updated, Unchanged, Inserted} {
+		counts.Add(outcome)
+	}
+
+	if counts.Inserted != 3 || counts.Updated != 1 || counts.Unchanged != 1 {
+		t.Errorf("Unexpected counts: %+v", counts)
+	}
+}
+
+func TestIncidentValues(t *testing.T) {
+
+	var tests = []struct {
+		input      tools.Incident
+		resolvedAt interface{}
+		priority   interface{}
+		assignees  []string
+	}{
+		{tools.Incident{Status: "triggered", LastStatusChangeAt: "2018-06-01T12:00:00Z"}, nil, nil, []string{}},
+		{tools.Incident{
+			Status:             "resolved",
+			LastStatusChangeAt: "2018-06-01T12:00:00Z",
+			Priority:           &pagerduty.Priority{APIObject: pagerduty.APIObject{ID: "PPR0001"}, Name: "P1"},
+			Assignments:        []pagerduty.Assignment{{Assignee: pagerduty.APIObject{ID: "PUS0001"}}},
+		}, "2018-06-01T12:00:00Z", "PPR0001", []string{"PUS0001"}},
+	}
+
+	for _, test := range tests {
+		values := incidentValues(test.input)
+		if len(values) != len(incidentColumns) {
+			t.Fatalf("Expected %d values, got %d", len(incidentColumns), len(values))
+		}
+
+		row := make(map[string]interface{})
+		for i, column := range incidentColumns {
+			row[column] = values[i]
+		}
+
+		if row["resolved_at"] != test.resolvedAt {
+			t.Errorf("Expected resolved_at %v, got %v", test.resolvedAt, row["resolved_at"])
+		}
+		if row["priority_id"] != test.priority {
+			t.Errorf("Expected priority_id %v, got %v", test.priority, row["priority_id"])
+		}
+		if assignees := row["assignee_ids"].(*pq.StringArray); !reflect.DeepEqual([]string(*assignees), test.assignees) {
+			t.Errorf("Expected assignee_ids %v, got %v", test.assignees, *assignees)
+		}
+	}
+}
+
+func TestLogEntryValues(t *testing.T) {
+
+	var tests = []struct {
+		input            tools.LogEntry
+		userID           interface{}
+		assignedUserID   interface{}
+		notificationType interface{}
+		eventDetails     interface{}
+	}{
+		{tools.LogEntry{APIObject: pagerduty.APIObject{Type: "trigger_log_entry"},
+			EventDetails: map[string]interface{}{"description": "Disk full"}},
+			nil, nil, nil, `{"description":"Disk full"}`},
+		{tools.LogEntry{APIObject: pagerduty.APIObject{Type: "notify_log_entry"}, User: pagerduty.APIObject{ID: "PUS0001"},
+			Notification: tools.LogEntryNotification{Type: "sms_notification", Status: "success"}},
+			"PUS0001", nil, "sms_notification", nil},
+		{tools.LogEntry{APIObject: pagerduty.APIObject{Type: "assign_log_entry"},
+			Assignees: []pagerduty.APIObject{{ID: "PUS0002"}, {ID: "PUS0003"}}},
+			nil, "PUS0002", nil, nil},
+	}
+
+	for _, test := range tests {
+		values := logEntryValues(test.input)
+		if len(values) != len(logEntryColumns) {
+			t.Fatalf("Expected %d values, got %d", len(logEntryColumns), len(values))
+		}
+
+		row := make(map[string]interface{})
+		for i, column := range logEntryColumns {
+			row[column] = values[i]
+		}
+
+		for column, expected := range map[string]interface{}{
+			"user_id":           test.userID,
+			"assigned_user_id":  test.assignedUserID,
+			"notification_type": test.notificationType,
+			"event_details":     test.eventDetails,
+		} {
+			if row[column] != expected {
+				t.Errorf("%s: expected %s %v, got %v", test.input.APIObject.Type, column, expected, row[column])
+			}
+		}
+	}
+}
+
+func TestScheduleValues(t *testing.T) {
+
+	layer := scheduleLayerValues(tools.ScheduleLayer{ID: "PSL0001", ScheduleID: "PSC0001", Start: "2018-01-01T09:00:00Z"})
+	if len(layer) != len(scheduleLayerColumns) {
+		t.Fatalf("Expected %d values, got %d", len(scheduleLayerColumns), len(layer))
+	}
+	// A layer still in effect has no end
+	if layer[4] != nil {
+		t.Errorf("Expected end_at nil, got %v", layer[4])
+	}
+
+	for columns, values := range map[*[]string][]interface{}{
+		&scheduleLayerUserColumns: scheduleLayerUserValues(tools.ScheduleLayerUser{}),
+		&scheduleOverrideColumns:  scheduleOverrideValues(tools.ScheduleOverride{}),
+		&scheduleEntryColumns:     scheduleEntryValues(tools.ScheduleEntry{}),
+	} {
+		if len(values) != len(*columns) {
+			t.Errorf("Expected %d values for %v, got %d", len(*columns), *columns, len(values))
+		}
+	}
+}
+
+func TestServiceValues(t *testing.T) {
+
+	timeout := uint(1800)
+	values := serviceValues(tools.Service{APIObject: pagerduty.APIObject{ID: "PSV0001", Type: "service"},
+		AcknowledgementTimeout: &timeout})
+	if len(values) != len(serviceColumns) {
+		t.Fatalf("Expected %d values, got %d", len(serviceColumns), len(values))
+	}
+
+	row := make(map[string]interface{})
+	for i, column := range serviceColumns {
+		row[column] = values[i]
+	}
+
+	// A disabled timeout is null in the API
+	if row["auto_resolve_timeout"] != nil {
+		t.Errorf("Expected auto_resolve_timeout nil, got %v", row["auto_resolve_timeout"])
+	}
+	if row["acknowledgement_timeout"] != int64(1800) {
+		t.Errorf("Expected acknowledgement_timeout 1800, got %v", row["acknowledgement_timeout"])
+	}
+
+	for columns, values := range map[*[]string][]interface{}{
+		&serviceIntegrationColumns: serviceIntegrationValues(tools.ServiceIntegration{}),
+		&businessServiceColumns:    businessServiceValues(tools.BusinessService{}),
+		&serviceDependencyColumns:  serviceDependencyValues(tools.ServiceDependency{}),
+	} {
+		if len(values) != len(*columns) {
+			t.Errorf("Expected %d values for %v, got %d", len(*columns), *columns, len(values))
+		}
+	}
+}
+
+func TestAlertValues(t *testing.T) {
+
+	values := alertValues(tools.Alert{APIObject: pagerduty.APIObject{ID: "PAL0001"}, Status: "triggered",
+		Body: map[string]interface{}{"details": map[string]interface{}{"host": "db-1"}}})
+	if len(values) != len(alertColumns) {
+		t.Fatalf("Expected %d values, got %d", len(alertColumns), len(values))
+	}
+
+	row := make(map[string]interface{})
+	for i, column := range alertColumns {
+		row[column] = values[i]
+	}
+
+	if row["resolved_at"] != nil {
+		t.Errorf("Expected resolved_at nil, got %v", row["resolved_at"])
+	}
+	if row["body"] != `{"details":{"host":"db-1"}}` {
+		t.Errorf("Expected the body as JSON, got %v", row["body"])
+	}
+}
@@ -0,0 +1,56 @@
+// Package postgrestest opens scratch databases for tests needing PostgreSQL.
+package postgrestest
+
+import (
+	".."
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// OpenDB connects to TEST_DATABASE_URL with a freshly migrated scratch schema that is dropped when
+// the test or benchmark ends. Tests needing a database are skipped when TEST_DATABASE_URL isn't set.
+func OpenDB(tb testing.TB) *postgres.DB {
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_URL not set")
+	}
+
+	schema := fmt.Sprintf("pd2pg_test_%d", time.Now().UnixNano())
+
+	admin, err := postgres.DatabaseConnect(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	separator := " "
+	if strings.Contains(dsn, "://") {
+		separator = "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+	}
+	db, err := postgres.DatabaseConnect(dsn + separator + "search_path=" + schema)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	_, err = db.MigrateUp()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return db
+}
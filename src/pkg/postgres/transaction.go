@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// InTransaction runs fn against a store bound to a single transaction, used to refresh dimension
+// tables atomically. The transaction commits when fn returns nil and rolls back otherwise, so
+// readers see either the previous snapshot or the new one, never a half-filled table.
+func (db *DB) InTransaction(fn func(store ReportingStore) error) (err error) {
+
+	// Already inside a transaction, join it
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return wrapError("begin transaction", "", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(&DB{DB: db.DB, tx: tx})
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			fmt.Println("Rollback failed:", rollbackErr)
+		}
+		return err
+	}
+
+	return wrapError("commit transaction", "", tx.Commit())
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// withSavepoint runs fn on the open transaction inside its own savepoint, so a row rejected by a
+// constraint can be skipped without aborting the whole transaction. Outside a transaction fn
+// runs directly against the database.
+func (db *DB) withSavepoint(fn func(q queryer) error) error {
+
+	if db.tx == nil {
+		return fn(db.DB)
+	}
+
+	_, err := db.tx.Exec("SAVEPOINT statement")
+	if err != nil {
+		return err
+	}
+
+	err = fn(db.tx)
+	if err != nil && err != sql.ErrNoRows {
+		if _, rollbackErr := db.tx.Exec("ROLLBACK TO SAVEPOINT statement"); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if _, releaseErr := db.tx.Exec("RELEASE SAVEPOINT statement"); releaseErr != nil {
+		return releaseErr
+	}
+
+	return err
+}
+
+// Exec runs on the open transaction when there is one, see withSavepoint
+func (db *DB) Exec(query string, args ...interface{}) (res sql.Result, err error) {
+
+	err = db.withSavepoint(func(q queryer) error {
+		res, err = q.Exec(query, args...)
+		return err
+	})
+
+	return res, err
+}
+
+// Query runs on the open transaction when there is one
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+
+	if db.tx == nil {
+		return db.DB.Query(query, args...)
+	}
+
+	return db.tx.Query(query, args...)
+}
+
+// QueryRow runs on the open transaction when there is one
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+
+	if db.tx == nil {
+		return db.DB.QueryRow(query, args...)
+	}
+
+	return db.tx.QueryRow(query, args...)
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// UpsertOutcome tells what an upsert did to the stored row
+type UpsertOutcome int
+
+const (
+	Unchanged UpsertOutcome = iota
+	Inserted
+	Updated
+)
+
+// UpsertCounts tallies upsert outcomes over a run
+type UpsertCounts struct {
+	Inserted  int   `json:"inserted"`
+	Updated   int   `json:"updated"`
+	Unchanged int   `json:"unchanged"`
+	Deleted   int64 `json:"deleted"`
+}
+
+func (c *UpsertCounts) Add(outcome UpsertOutcome) {
+	switch outcome {
+	case Inserted:
+		c.Inserted++
+	case Updated:
+		c.Updated++
+	default:
+		c.Unchanged++
+	}
+}
+
+// upsertStatement builds an INSERT ... ON CONFLICT DO UPDATE keyed by the first column. Stored rows
+// are only rewritten when a column actually changed, RETURNING (xmax = 0) is true for a fresh insert
+// and no row comes back at all when the stored row was already up to date.
+func upsertStatement(table string, columns ...string) string {
+
+	key := columns[0]
+	values := make([]string, len(columns))
+	assignments := make([]string, 0, len(columns)-1)
+	stored := make([]string, 0, len(columns)-1)
+	excluded := make([]string, 0, len(columns)-1)
+
+	for i, column := range columns {
+		values[i] = fmt.Sprintf("$%d", i+1)
+		if column == key {
+			continue
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		stored = append(stored, "t."+column)
+		excluded = append(excluded, "EXCLUDED."+column)
+	}
+
+	return fmt.Sprintf(`
+	INSERT INTO %s AS t (%s)
+	VALUES (%s)
+	ON CONFLICT (%s) DO UPDATE SET %s
+	WHERE (%s) IS DISTINCT FROM (%s)
+	RETURNING (xmax = 0)`,
+		table, strings.Join(columns, ", "), strings.Join(values, ", "), key,
+		strings.Join(assignments, ", "), strings.Join(stored, ", "), strings.Join(excluded, ", "))
+}
+
+// upsert runs an upsertStatement and reports what it did to the stored row
+func (db *DB) upsert(table string, sqlStatement string, args ...interface{}) (UpsertOutcome, error) {
+
+	var inserted bool
+
+	err := db.withSavepoint(func(q queryer) error {
+		return q.QueryRow(sqlStatement, args...).Scan(&inserted)
+	})
+
+	switch {
+	case err == sql.ErrNoRows:
+		return Unchanged, nil
+	case err != nil:
+		return Unchanged, wrapError("update "+table, table, err)
+	case inserted:
+		return Inserted, nil
+	default:
+		return Updated, nil
+	}
+}
@@ -3,6 +3,8 @@ package tools
 import (
 	"github.com/PagerDuty/go-pagerduty"
 	"github.com/jeevatkm/go-model"
+	"strconv"
+	"strings"
 )
 
 // GetMappedEscalationPolicies - model.Copy returns a slice of errors if any occur in case we want to do something with them.
@@ -11,6 +13,10 @@ func GetMappedEscalationPolicies(policies []pagerduty.EscalationPolicy) []Escala
 	for i := range policies {
 		nextPolicy := EscalationsPolicy{}
 		model.Copy(&nextPolicy, policies[i])
+		nextPolicy.TeamIDs = []string{}
+		for _, team := range policies[i].Teams {
+			nextPolicy.TeamIDs = append(nextPolicy.TeamIDs, team.ID)
+		}
 		escalationPoliciesToPersist = append(escalationPoliciesToPersist, nextPolicy)
 	}
 	return escalationPoliciesToPersist
@@ -28,16 +34,85 @@ func GetMappedEscalationRules(rules []pagerduty.EscalationRule, policyID string)
 	return escalationRulesToPersist
 }
 
-func GetMappedUsers(users []pagerduty.User) []User {
+func GetMappedUsers(users []PagerDutyUser) []User {
 	usersToPersist := []User{}
 	for i := range users {
 		nextUser := User{}
-		model.Copy(&nextUser, users[i])
+		model.Copy(&nextUser, users[i].User)
+		nextUser.TimeZone = users[i].TimeZone
+		nextUser.TeamIDs = []string{}
+		for _, team := range users[i].Teams {
+			nextUser.TeamIDs = append(nextUser.TeamIDs, team.ID)
+		}
 		usersToPersist = append(usersToPersist, nextUser)
 	}
 	return usersToPersist
 }
 
+// GetMappedUserContactMethods lists the contact methods of users fetched with include[]=contact_methods
+func GetMappedUserContactMethods(users []PagerDutyUser) []UserContactMethod {
+	contactMethodsToPersist := []UserContactMethod{}
+	for i := range users {
+		for _, contactMethod := range users[i].ContactMethods {
+			nextContactMethod := UserContactMethod{}
+			model.Copy(&nextContactMethod, contactMethod)
+			nextContactMethod.UserID = users[i].ID
+			contactMethodsToPersist = append(contactMethodsToPersist, nextContactMethod)
+		}
+	}
+	return contactMethodsToPersist
+}
+
+// GetMappedUserNotificationRules lists the notification rules of users fetched with include[]=notification_rules
+func GetMappedUserNotificationRules(users []PagerDutyUser) []UserNotificationRule {
+	notificationRulesToPersist := []UserNotificationRule{}
+	for i := range users {
+		for _, rule := range users[i].NotificationRules {
+			nextRule := UserNotificationRule{}
+			model.Copy(&nextRule, rule)
+			nextRule.UserID = users[i].ID
+			nextRule.ContactMethodID = rule.ContactMethod.ID
+			nextRule.ContactMethodType = rule.ContactMethod.Type
+			notificationRulesToPersist = append(notificationRulesToPersist, nextRule)
+		}
+	}
+	return notificationRulesToPersist
+}
+
+func GetMappedPriorities(priorities []PagerDutyPriority) []Priority {
+	prioritiesToPersist := []Priority{}
+	for i := range priorities {
+		nextPriority := Priority{}
+		model.Copy(&nextPriority, priorities[i])
+		prioritiesToPersist = append(prioritiesToPersist, nextPriority)
+	}
+	return prioritiesToPersist
+}
+
+func GetMappedTeams(teams []pagerduty.Team) []Team {
+	teamsToPersist := []Team{}
+	for i := range teams {
+		nextTeam := Team{}
+		model.Copy(&nextTeam, teams[i])
+		teamsToPersist = append(teamsToPersist, nextTeam)
+	}
+	return teamsToPersist
+}
+
+func GetMappedTeamMembers(members []PagerDutyTeamMember, teamID string) []TeamMember {
+	membersToPersist := []TeamMember{}
+	for i := range members {
+		nextMember := TeamMember{
+			ID:     teamID + members[i].User.ID,
+			TeamID: teamID,
+			UserID: members[i].User.ID,
+			Role:   members[i].Role,
+		}
+		membersToPersist = append(membersToPersist, nextMember)
+	}
+	return membersToPersist
+}
+
 func GetMappedSchedules(schedules []pagerduty.Schedule) []Schedule {
 	schedulesToPersist := []Schedule{}
 	for i := range schedules {
@@ -48,16 +123,141 @@ func GetMappedSchedules(schedules []pagerduty.Schedule) []Schedule {
 	return schedulesToPersist
 }
 
+// GetMappedScheduleLayers flattens the layers of a schedule and the users rotating through them
+func GetMappedScheduleLayers(schedule pagerduty.Schedule) ([]ScheduleLayer, []ScheduleLayerUser) {
+	layersToPersist := []ScheduleLayer{}
+	layerUsersToPersist := []ScheduleLayerUser{}
+	for i := range schedule.ScheduleLayers {
+		nextLayer := ScheduleLayer{}
+		model.Copy(&nextLayer, schedule.ScheduleLayers[i])
+		nextLayer.ID = schedule.ScheduleLayers[i].ID
+		nextLayer.ScheduleID = schedule.ID
+		layersToPersist = append(layersToPersist, nextLayer)
+
+		for y, user := range schedule.ScheduleLayers[i].Users {
+			layerUsersToPersist = append(layerUsersToPersist, ScheduleLayerUser{
+				ID:       nextLayer.ID + ":" + strconv.Itoa(y),
+				LayerID:  nextLayer.ID,
+				UserID:   user.User.ID,
+				Position: y,
+			})
+		}
+	}
+	return layersToPersist, layerUsersToPersist
+}
+
+func GetMappedScheduleOverrides(overrides []pagerduty.Override, scheduleID string) []ScheduleOverride {
+	overridesToPersist := []ScheduleOverride{}
+	for i := range overrides {
+		nextOverride := ScheduleOverride{}
+		model.Copy(&nextOverride, overrides[i])
+		nextOverride.ScheduleID = scheduleID
+		nextOverride.UserID = overrides[i].User.ID
+		overridesToPersist = append(overridesToPersist, nextOverride)
+	}
+	return overridesToPersist
+}
+
+// GetMappedScheduleEntries keys the final schedule entries by schedule and start
+func GetMappedScheduleEntries(schedule pagerduty.Schedule) []ScheduleEntry {
+	entriesToPersist := []ScheduleEntry{}
+	for _, entry := range schedule.FinalSchedule.RenderedScheduleEntries {
+		entriesToPersist = append(entriesToPersist, ScheduleEntry{
+			ID:         schedule.ID + ":" + entry.Start,
+			ScheduleID: schedule.ID,
+			UserID:     entry.User.ID,
+			Start:      entry.Start,
+			End:        entry.End,
+		})
+	}
+	return entriesToPersist
+}
+
 func GetMappedServices(services []pagerduty.Service) []Service {
 	servicesToPersist := []Service{}
 	for i := range services {
 		nextService := Service{}
 		model.Copy(&nextService, services[i])
+		nextService.TeamIDs = []string{}
+		for _, team := range services[i].Teams {
+			nextService.TeamIDs = append(nextService.TeamIDs, team.ID)
+		}
+		nextService.EscalationPolicyID = services[i].EscalationPolicy.ID
+		nextService.CreatedAt = services[i].CreateAt
+		if rule := services[i].IncidentUrgencyRule; rule != nil {
+			nextService.UrgencyRuleType = rule.Type
+			nextService.Urgency = rule.Urgency
+			if rule.DuringSupportHours != nil {
+				nextService.UrgencyDuringSupportHours = rule.DuringSupportHours.Urgency
+			}
+			if rule.OutsideSupportHours != nil {
+				nextService.UrgencyOutsideSupportHours = rule.OutsideSupportHours.Urgency
+			}
+		}
+		if hours := services[i].SupportHours; hours != nil {
+			nextService.SupportHoursTimeZone = hours.Timezone
+			nextService.SupportHoursStartTime = hours.StartTime
+			nextService.SupportHoursEndTime = hours.EndTime
+			for _, day := range hours.DaysOfWeek {
+				nextService.SupportHoursDaysOfWeek = append(nextService.SupportHoursDaysOfWeek, int64(day))
+			}
+		}
 		servicesToPersist = append(servicesToPersist, nextService)
 	}
 	return servicesToPersist
 }
 
+// GetMappedServiceIntegrations lists the integrations of services fetched with include[]=integrations
+func GetMappedServiceIntegrations(services []pagerduty.Service) []ServiceIntegration {
+	integrationsToPersist := []ServiceIntegration{}
+	for i := range services {
+		for _, integration := range services[i].Integrations {
+			nextIntegration := ServiceIntegration{}
+			model.Copy(&nextIntegration, integration)
+			nextIntegration.ServiceID = services[i].ID
+			if integration.Vendor != nil {
+				nextIntegration.VendorID = integration.Vendor.ID
+				nextIntegration.VendorName = integration.Vendor.Summary
+			}
+			integrationsToPersist = append(integrationsToPersist, nextIntegration)
+		}
+	}
+	return integrationsToPersist
+}
+
+func GetMappedBusinessServices(businessServices []PagerDutyBusinessService) []BusinessService {
+	businessServicesToPersist := []BusinessService{}
+	for i := range businessServices {
+		nextBusinessService := BusinessService{}
+		model.Copy(&nextBusinessService, businessServices[i])
+		if businessServices[i].Team != nil {
+			nextBusinessService.TeamID = businessServices[i].Team.ID
+		}
+		businessServicesToPersist = append(businessServicesToPersist, nextBusinessService)
+	}
+	return businessServicesToPersist
+}
+
+// GetMappedServiceDependencies keeps each relationship once, the same one is returned for both of its ends
+func GetMappedServiceDependencies(dependencies []PagerDutyServiceDependency) []ServiceDependency {
+	dependenciesToPersist := []ServiceDependency{}
+	seen := make(map[string]bool)
+	for i := range dependencies {
+		if seen[dependencies[i].ID] {
+			continue
+		}
+		seen[dependencies[i].ID] = true
+		dependenciesToPersist = append(dependenciesToPersist, ServiceDependency{
+			ID:                    dependencies[i].ID,
+			SupportingServiceID:   dependencies[i].SupportingService.ID,
+			SupportingServiceType: dependencies[i].SupportingService.Type,
+			DependentServiceID:    dependencies[i].DependentService.ID,
+			DependentServiceType:  dependencies[i].DependentService.Type,
+		})
+	}
+	return dependenciesToPersist
+}
+
 func GetMappedIncidents(incidents []pagerduty.Incident) []Incident {
 	incidentsToPersist := []Incident{}
 	for i := range incidents {
@@ -68,7 +268,7 @@ func GetMappedIncidents(incidents []pagerduty.Incident) []Incident {
 	return incidentsToPersist
 }
 
-func GetMappedLogEntries(logEntries []pagerduty.LogEntry) []LogEntry {
+func GetMappedLogEntries(logEntries []PagerDutyLogEntry) []LogEntry {
 	logEntriesToPersist := []LogEntry{}
 	for i := range logEntries {
 		nextLogEntry := LogEntry{}
@@ -77,3 +277,45 @@ func GetMappedLogEntries(logEntries []pagerduty.LogEntry) []LogEntry {
 	}
 	return logEntriesToPersist
 }
+
+func GetMappedAlerts(alerts []PagerDutyAlert) []Alert {
+	alertsToPersist := []Alert{}
+	for i := range alerts {
+		nextAlert := Alert{}
+		model.Copy(&nextAlert, alerts[i])
+		alertsToPersist = append(alertsToPersist, nextAlert)
+	}
+	return alertsToPersist
+}
+
+func GetMappedIncidentNotes(notes []pagerduty.IncidentNote, incidentID string) []IncidentNote {
+	notesToPersist := []IncidentNote{}
+	for i := range notes {
+		nextNote := IncidentNote{}
+		model.Copy(&nextNote, notes[i])
+		nextNote.IncidentID = incidentID
+		nextNote.UserID = notes[i].User.ID
+		notesToPersist = append(notesToPersist, nextNote)
+	}
+	return notesToPersist
+}
+
+// GetMappedOnCallShifts keys each shift by who was on call where and when it started. A shift reaching
+// across several windows comes back with the same start every time, so it is stored only once.
+func GetMappedOnCallShifts(onCalls []pagerduty.OnCall) []OnCallShift {
+	shiftsToPersist := []OnCallShift{}
+	for i := range onCalls {
+		nextShift := OnCallShift{
+			UserID:             onCalls[i].User.ID,
+			ScheduleID:         onCalls[i].Schedule.ID,
+			EscalationPolicyID: onCalls[i].EscalationPolicy.ID,
+			EscalationLevel:    onCalls[i].EscalationLevel,
+			Start:              onCalls[i].Start,
+			End:                onCalls[i].End,
+		}
+		nextShift.ID = strings.Join([]string{nextShift.UserID, nextShift.EscalationPolicyID,
+			strconv.FormatUint(uint64(nextShift.EscalationLevel), 10), nextShift.ScheduleID, nextShift.Start}, ":")
+		shiftsToPersist = append(shiftsToPersist, nextShift)
+	}
+	return shiftsToPersist
+}
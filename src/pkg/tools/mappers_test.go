@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"encoding/json"
 	"github.com/PagerDuty/go-pagerduty"
 	"reflect"
 	"testing"
@@ -64,6 +65,135 @@ func TestGetMappedEscalationRules(t *testing.T) {
 	assertEqual(t, 0, result[0].LevelIndex)
 }
 
+func TestGetMappedPriorities(t *testing.T) {
+
+	var priority PagerDutyPriority
+	err := json.Unmarshal([]byte(`{"id":"PPR0001","type":"priority","name":"P1",
+		"description":"Critical, customer facing","color":"a8171c","order":512}`), &priority)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result = GetMappedPriorities([]PagerDutyPriority{priority})
+
+	assertEqual(t, "PPR0001", result[0].APIObject.ID)
+	assertEqual(t, "P1", result[0].Name)
+	assertEqual(t, "Critical, customer facing", result[0].Description)
+	assertEqual(t, "a8171c", result[0].Color)
+	assertEqual(t, 512, result[0].Order)
+}
+
+func TestGetMappedTeams(t *testing.T) {
+
+	testTeam := pagerduty.Team{
+		APIObject:   pagerduty.APIObject{ID: "team1"},
+		Name:        "Infrastructure",
+		Description: "Databases and networking",
+	}
+
+	var result = GetMappedTeams([]pagerduty.Team{testTeam})
+
+	assertEqual(t, result[0].APIObject.ID, "team1")
+	assertEqual(t, result[0].Name, testTeam.Name)
+	assertEqual(t, result[0].Description, testTeam.Description)
+
+	var members = GetMappedTeamMembers([]PagerDutyTeamMember{
+		{User: pagerduty.APIObject{ID: "user1"}, Role: "manager"},
+	}, "team1")
+
+	assertEqual(t, members[0].ID, "team1user1")
+	assertEqual(t, members[0].UserID, "user1")
+	assertEqual(t, members[0].Role, "manager")
+}
+
+func TestGetMappedTeamLinks(t *testing.T) {
+
+	users := GetMappedUsers([]PagerDutyUser{{User: pagerduty.User{Teams: []pagerduty.Team{
+		{APIObject: pagerduty.APIObject{ID: "team1"}}, {APIObject: pagerduty.APIObject{ID: "team2"}}}}}})
+	services := GetMappedServices([]pagerduty.Service{{Teams: []pagerduty.Team{{APIObject: pagerduty.APIObject{ID: "team1"}}}}})
+	policies := GetMappedEscalationPolicies([]pagerduty.EscalationPolicy{{Teams: []pagerduty.APIReference{{ID: "team2"}}}, {}})
+
+	assertEqual(t, users[0].TeamIDs, []string{"team1", "team2"})
+	assertEqual(t, services[0].TeamIDs, []string{"team1"})
+	assertEqual(t, policies[0].TeamIDs, []string{"team2"})
+	assertEqual(t, policies[1].TeamIDs, []string{})
+}
+
+func TestGetMappedUserDetails(t *testing.T) {
+
+	var user PagerDutyUser
+	err := json.Unmarshal([]byte(`{"id":"PUS0001","name":"Ada","email":"ada@example.com","time_zone":"Europe/London",
+		"role":"admin","job_title":"SRE","invitation_sent":true,
+		"contact_methods":[{"id":"PCM0001","type":"phone_contact_method","label":"Mobile","address":"7700900123","country_code":44}],
+		"notification_rules":[{"id":"PNR0001","type":"assignment_notification_rule","urgency":"high","start_delay_in_minutes":5,
+			"contact_method":{"id":"PCM0001","type":"phone_contact_method_reference"}}]}`), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := GetMappedUsers([]PagerDutyUser{user})
+	contactMethods := GetMappedUserContactMethods([]PagerDutyUser{user})
+	rules := GetMappedUserNotificationRules([]PagerDutyUser{user})
+
+	assertEqual(t, "Europe/London", users[0].TimeZone)
+	assertEqual(t, "admin", users[0].Role)
+	assertEqual(t, "SRE", users[0].JobTitle)
+	assertEqual(t, true, users[0].InvitationSent)
+	assertEqual(t, 1, len(contactMethods))
+	assertEqual(t, "PUS0001", contactMethods[0].UserID)
+	assertEqual(t, "phone_contact_method", contactMethods[0].Type)
+	assertEqual(t, 44, contactMethods[0].CountryCode)
+	assertEqual(t, 1, len(rules))
+	assertEqual(t, "PUS0001", rules[0].UserID)
+	assertEqual(t, "PCM0001", rules[0].ContactMethodID)
+	assertEqual(t, "phone_contact_method_reference", rules[0].ContactMethodType)
+	assertEqual(t, "high", rules[0].Urgency)
+	assertEqual(t, uint(5), rules[0].StartDelayInMinutes)
+}
+
+func TestGetMappedServiceDetails(t *testing.T) {
+
+	var service pagerduty.Service
+	err := json.Unmarshal([]byte(`{"id":"PSV0001","name":"Database","created_at":"2017-06-01T12:00:00Z",
+		"escalation_policy":{"id":"PEP0001"},
+		"incident_urgency_rule":{"type":"use_support_hours","during_support_hours":{"urgency":"high"},
+			"outside_support_hours":{"urgency":"low"}},
+		"support_hours":{"time_zone":"Europe/London","start_time":"09:00:00","end_time":"17:00:00","days_of_week":[1,5]},
+		"integrations":[{"id":"PIT0001","type":"generic_events_api_inbound_integration","vendor":{"id":"PVE0001","summary":"Datadog"}},
+			{"id":"PIT0002","type":"generic_email_inbound_integration"}]}`), &service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services := GetMappedServices([]pagerduty.Service{service})
+	integrations := GetMappedServiceIntegrations([]pagerduty.Service{service})
+
+	assertEqual(t, "PEP0001", services[0].EscalationPolicyID)
+	assertEqual(t, "2017-06-01T12:00:00Z", services[0].CreatedAt)
+	assertEqual(t, "use_support_hours", services[0].UrgencyRuleType)
+	assertEqual(t, "high", services[0].UrgencyDuringSupportHours)
+	assertEqual(t, "low", services[0].UrgencyOutsideSupportHours)
+	assertEqual(t, "Europe/London", services[0].SupportHoursTimeZone)
+	assertEqual(t, []int64{1, 5}, services[0].SupportHoursDaysOfWeek)
+	assertEqual(t, 2, len(integrations))
+	assertEqual(t, "PSV0001", integrations[0].ServiceID)
+	assertEqual(t, "generic_events_api_inbound_integration", integrations[0].Type)
+	assertEqual(t, "Datadog", integrations[0].VendorName)
+	assertEqual(t, "", integrations[1].VendorID)
+}
+
+func TestGetMappedServiceDependencies(t *testing.T) {
+
+	dependency := PagerDutyServiceDependency{ID: "PSD0001",
+		SupportingService: pagerduty.APIObject{ID: "PSV0001", Type: "service"},
+		DependentService:  pagerduty.APIObject{ID: "PBS0001", Type: "business_service"}}
+
+	var result = GetMappedServiceDependencies([]PagerDutyServiceDependency{dependency, dependency})
+
+	assertEqual(t, 1, len(result))
+	assertEqual(t, "PSV0001", result[0].SupportingServiceID)
+	assertEqual(t, "business_service", result[0].DependentServiceType)
+}
+
 func TestGetMappedSchedules(t *testing.T) {
 	var PagerDutySchedules []pagerduty.Schedule
 
@@ -82,6 +212,42 @@ func TestGetMappedSchedules(t *testing.T) {
 	assertEqual(t, result[0].APIObject.ID, testSchedule.APIObject.ID)
 }
 
+func TestGetMappedScheduleLayers(t *testing.T) {
+
+	testSchedule := pagerduty.Schedule{
+		APIObject: pagerduty.APIObject{ID: "schedule1"},
+		ScheduleLayers: []pagerduty.ScheduleLayer{{
+			APIObject:                 pagerduty.APIObject{ID: "layer1"},
+			Name:                      "Weekly",
+			Start:                     "2018-01-01T09:00:00Z",
+			RotationTurnLengthSeconds: 604800,
+			Users: []pagerduty.UserReference{
+				{User: pagerduty.APIObject{ID: "user1"}},
+				{User: pagerduty.APIObject{ID: "user2"}},
+			},
+		}},
+		FinalSchedule: pagerduty.ScheduleLayer{RenderedScheduleEntries: []pagerduty.RenderedScheduleEntry{
+			{Start: "2018-01-01T09:00:00Z", End: "2018-01-08T09:00:00Z", User: pagerduty.APIObject{ID: "user1"}},
+		}},
+	}
+
+	layers, layerUsers := GetMappedScheduleLayers(testSchedule)
+
+	assertEqual(t, layers[0].ID, "layer1")
+	assertEqual(t, layers[0].ScheduleID, "schedule1")
+	assertEqual(t, layers[0].Name, "Weekly")
+	assertEqual(t, layers[0].RotationTurnLengthSeconds, uint(604800))
+	assertEqual(t, layerUsers[1].ID, "layer1:1")
+	assertEqual(t, layerUsers[1].UserID, "user2")
+	assertEqual(t, layerUsers[1].Position, 1)
+
+	entries := GetMappedScheduleEntries(testSchedule)
+
+	assertEqual(t, entries[0].ID, "schedule1:2018-01-01T09:00:00Z")
+	assertEqual(t, entries[0].UserID, "user1")
+	assertEqual(t, entries[0].End, "2018-01-08T09:00:00Z")
+}
+
 func TestGetMappedServices(t *testing.T) {
 
 	var PagerDutyServices []pagerduty.Service
@@ -118,6 +284,14 @@ func TestGetMappedIncidents(t *testing.T) {
 			ID:   "trig1",
 			Type: "info",
 		},
+		Title:              "Disk full",
+		Status:             "resolved",
+		Urgency:            "high",
+		LastStatusChangeAt: "2018-06-01T12:00:00Z",
+		Priority:           &pagerduty.Priority{APIObject: pagerduty.APIObject{ID: "P1"}, Name: "P1"},
+		Assignments:        []pagerduty.Assignment{{Assignee: pagerduty.APIObject{ID: "user1"}}},
+		Teams:              []pagerduty.APIObject{{ID: "team1"}},
+		AlertCounts:        pagerduty.AlertCounts{All: 2, Resolved: 2},
 	}
 
 	PagerDutyIncidents = append(PagerDutyIncidents, testIncident)
@@ -127,46 +301,160 @@ func TestGetMappedIncidents(t *testing.T) {
 	assertEqual(t, result[0].IncidentNumber, testIncident.IncidentNumber)
 	assertEqual(t, result[0].APIObject.ID, testIncident.APIObject.ID)
 	assertEqual(t, result[0].FirstTriggerLogEntry.ID, testIncident.FirstTriggerLogEntry.ID)
+	assertEqual(t, result[0].Title, testIncident.Title)
+	assertEqual(t, result[0].Status, testIncident.Status)
+	assertEqual(t, result[0].Urgency, testIncident.Urgency)
+	assertEqual(t, result[0].LastStatusChangeAt, testIncident.LastStatusChangeAt)
+	assertEqual(t, result[0].Priority.Name, testIncident.Priority.Name)
+	assertEqual(t, result[0].Assignments[0].Assignee.ID, "user1")
+	assertEqual(t, result[0].Teams[0].ID, "team1")
+	assertEqual(t, result[0].AlertCounts.All, uint(2))
 }
 
 func TestGetMappedLogEntries(t *testing.T) {
 
-	var PagerDutyLogEntries []pagerduty.LogEntry
+	var tests = []struct {
+		name         string
+		json         string
+		agentID      string
+		userID       string
+		assignees    []string
+		notification LogEntryNotification
+		channel      LogEntryChannel
+		details      string
+	}{
+		{"trigger",
+			`{"id":"R01","type":"trigger_log_entry","created_at":"2018-06-01T12:00:00Z","incident":{"id":"PIN0001"},
+			"agent":{"id":"PSV0001","type":"service_reference"},
+			"channel":{"type":"api","summary":"Disk full","subject":"Disk full on db-1"},
+			"event_details":{"description":"Disk full on db-1"}}`,
+			"PSV0001", "", nil, LogEntryNotification{}, LogEntryChannel{Type: "api", Summary: "Disk full", Subject: "Disk full on db-1"}, "Disk full on db-1"},
+		{"notify",
+			`{"id":"R02","type":"notify_log_entry","created_at":"2018-06-01T12:00:05Z","incident":{"id":"PIN0001"},
+			"user":{"id":"PUS0001","type":"user_reference"},"channel":{"type":"auto"},
+			"notification":{"type":"sms_notification","status":"success","address":"+15555550100"}}`,
+			"", "PUS0001", nil, LogEntryNotification{Type: "sms_notification", Status: "success", Address: "+15555550100"}, LogEntryChannel{Type: "auto"}, ""},
+		{"acknowledge",
+			`{"id":"R03","type":"acknowledge_log_entry","created_at":"2018-06-01T12:03:00Z","incident":{"id":"PIN0001"},
+			"agent":{"id":"PUS0001","type":"user_reference"},"channel":{"type":"sms"}}`,
+			"PUS0001", "", nil, LogEntryNotification{}, LogEntryChannel{Type: "sms"}, ""},
+		{"assign",
+			`{"id":"R04","type":"assign_log_entry","created_at":"2018-06-01T12:04:00Z","incident":{"id":"PIN0001"},
+			"agent":{"id":"PUS0001","type":"user_reference"},"channel":{"type":"web_ui"},
+			"assignees":[{"id":"PUS0002","type":"user_reference"},{"id":"PUS0003","type":"user_reference"}]}`,
+			"PUS0001", "", []string{"PUS0002", "PUS0003"}, LogEntryNotification{}, LogEntryChannel{Type: "web_ui"}, ""},
+		{"escalate",
+			`{"id":"R05","type":"escalate_log_entry","created_at":"2018-06-01T12:30:00Z","incident":{"id":"PIN0001"},
+			"channel":{"type":"timeout"},"assignees":[{"id":"PUS0002","type":"user_reference"}]}`,
+			"", "", []string{"PUS0002"}, LogEntryNotification{}, LogEntryChannel{Type: "timeout"}, ""},
+		{"resolve",
+			`{"id":"R06","type":"resolve_log_entry","created_at":"2018-06-01T12:42:00Z","incident":{"id":"PIN0001"},
+			"agent":{"id":"PUS0002","type":"user_reference"},"channel":{"type":"web_ui"}}`,
+			"PUS0002", "", nil, LogEntryNotification{}, LogEntryChannel{Type: "web_ui"}, ""},
+		{"annotate",
+			`{"id":"R07","type":"annotate_log_entry","created_at":"2018-06-01T12:45:00Z","incident":{"id":"PIN0001"},
+			"agent":{"id":"PUS0002","type":"user_reference"},"channel":{"type":"note","summary":"Rebooted db-1"}}`,
+			"PUS0002", "", nil, LogEntryNotification{}, LogEntryChannel{Type: "note", Summary: "Rebooted db-1"}, ""},
+	}
 
-	testIncident := pagerduty.Incident{
-		IncidentNumber: 1,
-		IncidentKey:    "grumble grumble",
-		APIObject: pagerduty.APIObject{
-			ID:   "Incident11",
-			Type: "Disaster",
-		},
-		FirstTriggerLogEntry: pagerduty.APIObject{
-			ID:   "trig1",
-			Type: "info",
-		},
+	for _, test := range tests {
+		var entry PagerDutyLogEntry
+		err := json.Unmarshal([]byte(test.json), &entry)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		result := GetMappedLogEntries([]PagerDutyLogEntry{entry})[0]
+
+		assertEqual(t, test.name+"_log_entry", result.APIObject.Type)
+		assertEqual(t, "PIN0001", result.Incident.ID)
+		assertEqual(t, entry.CreatedAt, result.CreatedAt)
+		assertEqual(t, test.agentID, result.Agent.ID)
+		assertEqual(t, test.userID, result.User.ID)
+		if test.notification != result.Notification {
+			t.Errorf("%s: expected notification %+v, got %+v", test.name, test.notification, result.Notification)
+		}
+		if test.channel != result.Channel {
+			t.Errorf("%s: expected channel %+v, got %+v", test.name, test.channel, result.Channel)
+		}
+
+		var assignees []string
+		for _, assignee := range result.Assignees {
+			assignees = append(assignees, assignee.ID)
+		}
+		assertEqual(t, test.assignees, assignees)
+
+		description, _ := result.EventDetails["description"].(string)
+		assertEqual(t, test.details, description)
 	}
+}
 
-	testLogEntry := pagerduty.LogEntry{
-		CreatedAt: "01-Jun-2020",
-		APIObject: pagerduty.APIObject{
-			ID:   "log1",
-			Type: "trigger_log_entry",
-		},
-		Agent: pagerduty.Agent{
-			ID:   "agent001",
-			Type: "super_user",
-		},
-		Channel:  pagerduty.Channel{Type: "incident_channel"},
-		Incident: testIncident,
+func TestGetMappedOnCallShifts(t *testing.T) {
+
+	shift := pagerduty.OnCall{
+		User:             pagerduty.APIObject{ID: "user1"},
+		Schedule:         pagerduty.APIObject{ID: "schedule1"},
+		EscalationPolicy: pagerduty.APIObject{ID: "policy1"},
+		EscalationLevel:  1,
+		Start:            "2018-03-26T09:00:00Z",
+		End:              "2018-04-02T09:00:00Z",
 	}
+	permanent := pagerduty.OnCall{
+		User:             pagerduty.APIObject{ID: "user2"},
+		EscalationPolicy: pagerduty.APIObject{ID: "policy1"},
+		EscalationLevel:  2,
+	}
+
+	var result = GetMappedOnCallShifts([]pagerduty.OnCall{shift, permanent, shift})
+
+	assertEqual(t, result[0].ID, "user1:policy1:1:schedule1:2018-03-26T09:00:00Z")
+	assertEqual(t, result[0].UserID, "user1")
+	assertEqual(t, result[0].ScheduleID, "schedule1")
+	assertEqual(t, result[0].End, shift.End)
+	assertEqual(t, result[1].ID, "user2:policy1:2::")
+	// The same shift seen in another window maps to the same row
+	assertEqual(t, result[2].ID, result[0].ID)
+}
 
-	PagerDutyLogEntries = append(PagerDutyLogEntries, testLogEntry)
+func TestGetMappedAlerts(t *testing.T) {
+
+	var alert PagerDutyAlert
+	err := json.Unmarshal([]byte(`{"id":"PAL0001","type":"alert","summary":"Disk full on db-1",
+		"created_at":"2018-03-01T10:00:00Z","resolved_at":"2018-03-01T10:42:00Z","status":"resolved",
+		"alert_key":"db-disk-full","severity":"critical","incident":{"id":"PIN0001"},"service":{"id":"PSV0001"},
+		"integration":{"id":"PIT0001","type":"generic_events_api_inbound_integration_reference"},
+		"body":{"type":"alert_body","details":{"disk":"/dev/sda1","used":"100%"}}}`), &alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := GetMappedAlerts([]PagerDutyAlert{alert})[0]
+
+	assertEqual(t, "PAL0001", result.APIObject.ID)
+	assertEqual(t, "db-disk-full", result.AlertKey)
+	assertEqual(t, "critical", result.Severity)
+	assertEqual(t, "2018-03-01T10:42:00Z", result.ResolvedAt)
+	assertEqual(t, "PIN0001", result.Incident.ID)
+	assertEqual(t, "PIT0001", result.Integration.ID)
+	assertEqual(t, map[string]interface{}{"disk": "/dev/sda1", "used": "100%"}, result.Body["details"])
+}
+
+func TestGetMappedIncidentNotes(t *testing.T) {
+
+	testNote := pagerduty.IncidentNote{
+		ID:        "PNO0001",
+		User:      pagerduty.APIObject{ID: "PUS0001"},
+		Content:   "Rebooted db-1",
+		CreatedAt: "2018-03-01T10:30:00Z",
+	}
 
-	var result = GetMappedLogEntries(PagerDutyLogEntries)
+	var result = GetMappedIncidentNotes([]pagerduty.IncidentNote{testNote}, "PIN0001")
 
-	assertEqual(t, result[0].CreatedAt, testLogEntry.CreatedAt)
-	assertEqual(t, result[0].APIObject.ID, testLogEntry.APIObject.ID)
-	assertEqual(t, result[0].APIObject.Type, testLogEntry.APIObject.Type)
+	assertEqual(t, "PNO0001", result[0].ID)
+	assertEqual(t, "PIN0001", result[0].IncidentID)
+	assertEqual(t, "PUS0001", result[0].UserID)
+	assertEqual(t, testNote.Content, result[0].Content)
+	assertEqual(t, testNote.CreatedAt, result[0].CreatedAt)
 }
 
 func assertEqual(t *testing.T, e, g interface{}) (r bool) {
@@ -1,13 +1,14 @@
 package tools
 
 import (
+	"context"
 	"fmt"
 	"github.com/PagerDuty/go-pagerduty"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/ssm"
-	"log"
 	"os"
 	"strconv"
 	"time"
@@ -32,19 +33,90 @@ type EnvVariables struct {
 	IncrementalBuffer         int
 	IncrementalWindow         int
 	PagerDutyEpoch            time.Time
+	DeadlineMargin            int
+	SelfInvoke                bool
+	BatchSize                 int
+	AutoMigrate               bool
+	APIMaxAttempts            int
+	APIRetryBudget            int
+	APIConcurrency            int
+	APIRateLimit              int
+	ScheduleHorizon           int
 }
 
 type EscalationsPolicy struct {
 	APIObject pagerduty.APIObject
-	Name      string `API:"Name" DB:"name"`
-	NumLoops  uint   `API:"NumLoops" DB:"num_loops"`
+	Name      string   `API:"Name" DB:"name"`
+	NumLoops  uint     `API:"NumLoops" DB:"num_loops"`
+	TeamIDs   []string `API:"Teams.ID" DB:"team_ids"`
 }
 
 // Integration in PD API
 type Service struct {
-	APIObject pagerduty.APIObject
-	Name      string `API:"Name" DB:"name"`
-	Status    string `API:"Status" DB:"status"`
+	APIObject                  pagerduty.APIObject
+	Name                       string   `API:"Name" DB:"name"`
+	Status                     string   `API:"Status" DB:"status"`
+	TeamIDs                    []string `API:"Teams.ID" DB:"team_ids"`
+	Description                string   `API:"Description" DB:"description"`
+	EscalationPolicyID         string   `API:"EscalationPolicy.ID" DB:"escalation_policy_id"`
+	AutoResolveTimeout         *uint    `API:"AutoResolveTimeout" DB:"auto_resolve_timeout"`
+	AcknowledgementTimeout     *uint    `API:"AcknowledgementTimeout" DB:"acknowledgement_timeout"`
+	AlertCreation              string   `API:"AlertCreation" DB:"alert_creation"`
+	CreatedAt                  string   `API:"CreateAt" DB:"created_at"`
+	LastIncidentTimestamp      string   `API:"LastIncidentTimestamp" DB:"last_incident_at"`
+	UrgencyRuleType            string   `API:"IncidentUrgencyRule.Type" DB:"urgency_rule_type"`
+	Urgency                    string   `API:"IncidentUrgencyRule.Urgency" DB:"urgency"`
+	UrgencyDuringSupportHours  string   `API:"IncidentUrgencyRule.DuringSupportHours.Urgency" DB:"urgency_during_support_hours"`
+	UrgencyOutsideSupportHours string   `API:"IncidentUrgencyRule.OutsideSupportHours.Urgency" DB:"urgency_outside_support_hours"`
+	SupportHoursTimeZone       string   `API:"SupportHours.Timezone" DB:"support_hours_time_zone"`
+	SupportHoursStartTime      string   `API:"SupportHours.StartTime" DB:"support_hours_start_time"`
+	SupportHoursEndTime        string   `API:"SupportHours.EndTime" DB:"support_hours_end_time"`
+	SupportHoursDaysOfWeek     []int64  `API:"SupportHours.DaysOfWeek" DB:"support_hours_days_of_week"`
+}
+
+type ServiceIntegration struct {
+	ID         string `API:"ID" DB:"id"`
+	ServiceID  string `API:"N/A" DB:"service_id"`
+	Name       string `API:"Name" DB:"name"`
+	Type       string `API:"Type" DB:"type"`
+	VendorID   string `API:"Vendor.ID" DB:"vendor_id"`
+	VendorName string `API:"Vendor.Summary" DB:"vendor_name"`
+	CreatedAt  string `API:"CreatedAt" DB:"created_at"`
+}
+
+type BusinessService struct {
+	APIObject      pagerduty.APIObject
+	Name           string `API:"Name" DB:"name"`
+	Description    string `API:"Description" DB:"description"`
+	PointOfContact string `API:"PointOfContact" DB:"point_of_contact"`
+	TeamID         string `API:"Team.ID" DB:"team_id"`
+}
+
+// PagerDutyBusinessService is a business service as returned by the API, go-pagerduty has no
+// business services endpoint
+type PagerDutyBusinessService struct {
+	pagerduty.APIObject
+	Name           string               `json:"name"`
+	Description    string               `json:"description"`
+	PointOfContact string               `json:"point_of_contact"`
+	Team           *pagerduty.APIObject `json:"team"`
+}
+
+// ServiceDependency links a dependent service to the service it relies on, either end can be a
+// business or a technical service
+type ServiceDependency struct {
+	ID                    string `API:"ID" DB:"id"`
+	SupportingServiceID   string `API:"SupportingService.ID" DB:"supporting_service_id"`
+	SupportingServiceType string `API:"SupportingService.Type" DB:"supporting_service_type"`
+	DependentServiceID    string `API:"DependentService.ID" DB:"dependent_service_id"`
+	DependentServiceType  string `API:"DependentService.Type" DB:"dependent_service_type"`
+}
+
+// PagerDutyServiceDependency is a relationship as returned by the service dependencies endpoints
+type PagerDutyServiceDependency struct {
+	ID                string              `json:"id"`
+	SupportingService pagerduty.APIObject `json:"supporting_service"`
+	DependentService  pagerduty.APIObject `json:"dependent_service"`
 }
 
 type EscalationsRule struct {
@@ -67,9 +139,77 @@ type EscalationsRuleSchedule struct {
 }
 
 type User struct {
-	APIObject pagerduty.APIObject
-	Name      string `API:"Name" DB:"name"`
-	Email     string `API:"Email" DB:"email"`
+	APIObject      pagerduty.APIObject
+	Name           string   `API:"Name" DB:"name"`
+	Email          string   `API:"Email" DB:"email"`
+	TeamIDs        []string `API:"Teams.ID" DB:"team_ids"`
+	TimeZone       string   `API:"TimeZone" DB:"time_zone"`
+	Role           string   `API:"Role" DB:"role"`
+	JobTitle       string   `API:"JobTitle" DB:"job_title"`
+	InvitationSent bool     `API:"InvitationSent" DB:"invitation_sent"`
+}
+
+// PagerDutyUser is a user as returned by the API, go-pagerduty's User reads the time zone from "timezone"
+// while the API sends "time_zone"
+type PagerDutyUser struct {
+	pagerduty.User
+	TimeZone string `json:"time_zone"`
+}
+
+type UserContactMethod struct {
+	ID          string `API:"ID" DB:"id"`
+	UserID      string `API:"N/A" DB:"user_id"`
+	Type        string `API:"Type" DB:"type"`
+	Label       string `API:"Label" DB:"label"`
+	Address     string `API:"Address" DB:"address"`
+	CountryCode int    `API:"CountryCode" DB:"country_code"`
+	Blacklisted bool   `API:"Blacklisted" DB:"blacklisted"`
+}
+
+type UserNotificationRule struct {
+	ID                  string `API:"ID" DB:"id"`
+	UserID              string `API:"N/A" DB:"user_id"`
+	ContactMethodID     string `API:"ContactMethod.ID" DB:"contact_method_id"`
+	ContactMethodType   string `API:"ContactMethod.Type" DB:"contact_method_type"`
+	Urgency             string `API:"Urgency" DB:"urgency"`
+	StartDelayInMinutes uint   `API:"StartDelayInMinutes" DB:"start_delay_in_minutes"`
+}
+
+type Priority struct {
+	APIObject   pagerduty.APIObject
+	Name        string `API:"Name" DB:"name"`
+	Description string `API:"Description" DB:"description"`
+	Color       string `API:"Color" DB:"color"`
+	Order       int    `API:"Order" DB:"sort_order"`
+}
+
+// PagerDutyPriority is a priority as returned by the API, go-pagerduty has no priorities endpoint
+type PagerDutyPriority struct {
+	pagerduty.APIObject
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Color       string `json:"color"`
+	Order       int    `json:"order"`
+}
+
+type Team struct {
+	APIObject   pagerduty.APIObject
+	Name        string `API:"Name" DB:"name"`
+	Description string `API:"Description" DB:"description"`
+}
+
+// TeamMember is a user's membership of a team, Role is one of observer, responder or manager
+type TeamMember struct {
+	ID     string `API:"N/A" DB:"id"`
+	TeamID string `API:"N/A" DB:"team_id"`
+	UserID string `API:"User.ID" DB:"user_id"`
+	Role   string `API:"Role" DB:"role"`
+}
+
+// PagerDutyTeamMember is a member as returned by the API, go-pagerduty has no team members endpoint
+type PagerDutyTeamMember struct {
+	User pagerduty.APIObject `json:"user"`
+	Role string              `json:"role"`
 }
 
 type Incident struct {
@@ -80,15 +220,96 @@ type Incident struct {
 	Service              pagerduty.APIObject
 	EscalationPolicy     pagerduty.APIObject
 	FirstTriggerLogEntry pagerduty.APIObject
+	Title                string `API:"Title" DB:"title"`
+	Description          string `API:"Description" DB:"description"`
+	Status               string `API:"Status" DB:"status"`
+	Urgency              string `API:"Urgency" DB:"urgency"`
+	LastStatusChangeAt   string `API:"LastStatusChangeAt" DB:"last_status_change_at"`
+	Priority             *pagerduty.Priority
+	Acknowledgements     []pagerduty.Acknowledgement
+	Assignments          []pagerduty.Assignment
+	Teams                []pagerduty.APIObject
+	AlertCounts          pagerduty.AlertCounts
 }
 
 type LogEntry struct {
-	APIObject pagerduty.APIObject
-	CreatedAt string `API:"CreatedAt" DB:"created_at"`
-	Incident  pagerduty.Incident
-	Agent     pagerduty.Agent
-	Channel   pagerduty.Channel
-	Teams     []pagerduty.Team
+	APIObject    pagerduty.APIObject
+	CreatedAt    string `API:"CreatedAt" DB:"created_at"`
+	Incident     pagerduty.APIObject
+	Agent        pagerduty.APIObject
+	User         pagerduty.APIObject
+	Assignees    []pagerduty.APIObject
+	Channel      LogEntryChannel
+	Notification LogEntryNotification
+	EventDetails map[string]interface{}
+}
+
+// PagerDutyLogEntry is a log entry as returned by the API. go-pagerduty's LogEntry drops the user,
+// assignees, notification and most of the channel, so log entries are decoded into this instead.
+type PagerDutyLogEntry struct {
+	pagerduty.APIObject
+	CreatedAt    string                 `json:"created_at"`
+	Incident     pagerduty.APIObject    `json:"incident"`
+	Agent        pagerduty.APIObject    `json:"agent"`
+	User         pagerduty.APIObject    `json:"user"`
+	Assignees    []pagerduty.APIObject  `json:"assignees"`
+	Channel      LogEntryChannel        `json:"channel"`
+	Notification LogEntryNotification   `json:"notification"`
+	EventDetails map[string]interface{} `json:"event_details"`
+	Teams        []pagerduty.APIObject  `json:"teams"`
+}
+
+type Alert struct {
+	APIObject   pagerduty.APIObject
+	Summary     string `API:"Summary" DB:"summary"`
+	CreatedAt   string `API:"CreatedAt" DB:"created_at"`
+	ResolvedAt  string `API:"ResolvedAt" DB:"resolved_at"`
+	Status      string `API:"Status" DB:"status"`
+	AlertKey    string `API:"AlertKey" DB:"alert_key"`
+	Severity    string `API:"Severity" DB:"severity"`
+	Incident    pagerduty.APIObject
+	Service     pagerduty.APIObject
+	Integration pagerduty.APIObject
+	Body        map[string]interface{}
+}
+
+// PagerDutyAlert is an alert of an incident as returned by the API, body holds the details the
+// monitoring tool sent
+type PagerDutyAlert struct {
+	pagerduty.APIObject
+	Summary     string                 `json:"summary"`
+	CreatedAt   string                 `json:"created_at"`
+	ResolvedAt  string                 `json:"resolved_at"`
+	Status      string                 `json:"status"`
+	AlertKey    string                 `json:"alert_key"`
+	Severity    string                 `json:"severity"`
+	Incident    pagerduty.APIObject    `json:"incident"`
+	Service     pagerduty.APIObject    `json:"service"`
+	Integration pagerduty.APIObject    `json:"integration"`
+	Body        map[string]interface{} `json:"body"`
+}
+
+// IncidentNote is a note a responder added to an incident's timeline
+type IncidentNote struct {
+	ID         string `API:"ID" DB:"id"`
+	IncidentID string `API:"N/A" DB:"incident_id"`
+	UserID     string `API:"User.ID" DB:"user_id"`
+	Content    string `API:"Content" DB:"content"`
+	CreatedAt  string `API:"CreatedAt" DB:"created_at"`
+}
+
+// LogEntryChannel is how the event reached PagerDuty, e.g. the API, email or the web UI
+type LogEntryChannel struct {
+	Type    string `json:"type" DB:"channel_type"`
+	Summary string `json:"summary" DB:"channel_summary"`
+	Subject string `json:"subject" DB:"channel_subject"`
+}
+
+// LogEntryNotification is set on notify log entries
+type LogEntryNotification struct {
+	Type    string `json:"type" DB:"notification_type"`
+	Status  string `json:"status" DB:"notification_status"`
+	Address string `json:"address" DB:"notification_address"`
 }
 
 type Schedule struct {
@@ -96,6 +317,57 @@ type Schedule struct {
 	Name      string `API:"Name" DB:"name"`
 }
 
+// ScheduleLayer is a rotation of a schedule, End is empty while the layer is in effect
+type ScheduleLayer struct {
+	ID                         string  `API:"ID" DB:"id"`
+	ScheduleID                 string  `API:"N/A" DB:"schedule_id"`
+	Name                       string  `API:"Name" DB:"name"`
+	Start                      string  `API:"Start" DB:"start_at"`
+	End                        string  `API:"End" DB:"end_at"`
+	RotationVirtualStart       string  `API:"RotationVirtualStart" DB:"rotation_virtual_start"`
+	RotationTurnLengthSeconds  uint    `API:"RotationTurnLengthSeconds" DB:"rotation_turn_length_seconds"`
+	RenderedCoveragePercentage float64 `API:"RenderedCoveragePercentage" DB:"rendered_coverage_percentage"`
+}
+
+// ScheduleLayerUser is a user taking turns in a layer, Position is the user's place in the rotation
+type ScheduleLayerUser struct {
+	ID       string `API:"N/A" DB:"id"`
+	LayerID  string `API:"N/A" DB:"schedule_layer_id"`
+	UserID   string `API:"User.ID" DB:"user_id"`
+	Position int    `API:"N/A" DB:"position"`
+}
+
+// ScheduleOverride replaces whoever the layers put on call between Start and End
+type ScheduleOverride struct {
+	ID         string `API:"ID" DB:"id"`
+	ScheduleID string `API:"N/A" DB:"schedule_id"`
+	UserID     string `API:"User.ID" DB:"user_id"`
+	Start      string `API:"Start" DB:"start_at"`
+	End        string `API:"End" DB:"end_at"`
+}
+
+// ScheduleEntry is a span of the final rendered schedule, layers and overrides applied. ID is
+// derived from the schedule and the start since the API doesn't return one.
+type ScheduleEntry struct {
+	ID         string `API:"N/A" DB:"id"`
+	ScheduleID string `API:"N/A" DB:"schedule_id"`
+	UserID     string `API:"User.ID" DB:"user_id"`
+	Start      string `API:"Start" DB:"start_at"`
+	End        string `API:"End" DB:"end_at"`
+}
+
+// OnCallShift is a period a user was on call for an escalation policy level, through a schedule
+// or directly. ID is derived from the other fields since the API doesn't return one.
+type OnCallShift struct {
+	ID                 string `API:"N/A" DB:"id"`
+	UserID             string `API:"User.ID" DB:"user_id"`
+	ScheduleID         string `API:"Schedule.ID" DB:"schedule_id"`
+	EscalationPolicyID string `API:"EscalationPolicy.ID" DB:"escalation_policy_id"`
+	EscalationLevel    uint   `API:"EscalationLevel" DB:"escalation_level"`
+	Start              string `API:"Start" DB:"start_at"`
+	End                string `API:"End" DB:"end_at"`
+}
+
 type UserSchedule struct {
 	ID         string `API:"ID" DB:"id"`
 	UserID     string `API:"N/A" DB:"user_id" `
@@ -106,7 +378,21 @@ type UserSchedule struct {
 
 var EnvironmentVariables = new(EnvVariables)
 
-func PopulateEnvVariables() {
+// ConfigError is a missing or malformed setting, retrying won't help until the configuration is fixed
+type ConfigError struct {
+	Variable string
+	Err      error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration %s: %v", e.Variable, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func PopulateEnvVariables() error {
 
 	// Populate struct content
 	var err error
@@ -119,37 +405,101 @@ func PopulateEnvVariables() {
 	EnvironmentVariables.DatabasePasswordParameter = os.Getenv("DATABASE_PASSWORD_PARAMETER")
 	PaginationLimitInt, err := strconv.Atoi(os.Getenv("PAGINATION_LIMIT"))
 	if err != nil {
-		fmt.Println(err)
+		return &ConfigError{Variable: "PAGINATION_LIMIT", Err: err}
 	}
 	EnvironmentVariables.PaginationLimit = uint(PaginationLimitInt)
 	EnvironmentVariables.IncrementalBuffer, err = strconv.Atoi(os.Getenv("INCREMENTAL_BUFFER"))
 	if err != nil {
-		fmt.Println(err)
+		return &ConfigError{Variable: "INCREMENTAL_BUFFER", Err: err}
 	}
 	EnvironmentVariables.IncrementalWindow, err = strconv.Atoi(os.Getenv("INCREMENTAL_WINDOW"))
 	if err != nil {
-		fmt.Println(err)
+		return &ConfigError{Variable: "INCREMENTAL_WINDOW", Err: err}
 	}
 	EnvironmentVariables.PagerDutyEpoch, err = time.Parse(time.RFC3339, os.Getenv("PAGERDUTY_EPOCH"))
 	if err != nil {
-		fmt.Println(err)
+		return &ConfigError{Variable: "PAGERDUTY_EPOCH", Err: err}
+	}
+
+	// Stop windowed transfers this many seconds before the Lambda timeout
+	EnvironmentVariables.DeadlineMargin = 30
+	if os.Getenv("DEADLINE_MARGIN") != "" {
+		EnvironmentVariables.DeadlineMargin, err = strconv.Atoi(os.Getenv("DEADLINE_MARGIN"))
+		if err != nil || EnvironmentVariables.DeadlineMargin <= 0 {
+			return &ConfigError{Variable: "DEADLINE_MARGIN", Err: fmt.Errorf("must be a positive integer, got %q", os.Getenv("DEADLINE_MARGIN"))}
+		}
+	}
+	EnvironmentVariables.SelfInvoke = os.Getenv("SELF_INVOKE") == "true"
+	EnvironmentVariables.AutoMigrate = os.Getenv("AUTO_MIGRATE") == "true"
+
+	// Attempts per PagerDuty request and total seconds a request may spend waiting between them
+	EnvironmentVariables.APIMaxAttempts = 5
+	if os.Getenv("PAGERDUTY_MAX_ATTEMPTS") != "" {
+		EnvironmentVariables.APIMaxAttempts, err = strconv.Atoi(os.Getenv("PAGERDUTY_MAX_ATTEMPTS"))
+		if err != nil || EnvironmentVariables.APIMaxAttempts <= 0 {
+			return &ConfigError{Variable: "PAGERDUTY_MAX_ATTEMPTS", Err: fmt.Errorf("must be a positive integer, got %q", os.Getenv("PAGERDUTY_MAX_ATTEMPTS"))}
+		}
+	}
+	EnvironmentVariables.APIRetryBudget = 120
+	if os.Getenv("PAGERDUTY_RETRY_BUDGET") != "" {
+		EnvironmentVariables.APIRetryBudget, err = strconv.Atoi(os.Getenv("PAGERDUTY_RETRY_BUDGET"))
+		if err != nil || EnvironmentVariables.APIRetryBudget < 0 {
+			return &ConfigError{Variable: "PAGERDUTY_RETRY_BUDGET", Err: fmt.Errorf("must be a non-negative integer, got %q", os.Getenv("PAGERDUTY_RETRY_BUDGET"))}
+		}
+	}
+
+	// Per-entity PagerDuty calls made at once, and requests per minute across all of them
+	EnvironmentVariables.APIConcurrency = 4
+	if os.Getenv("PAGERDUTY_CONCURRENCY") != "" {
+		EnvironmentVariables.APIConcurrency, err = strconv.Atoi(os.Getenv("PAGERDUTY_CONCURRENCY"))
+		if err != nil || EnvironmentVariables.APIConcurrency <= 0 {
+			return &ConfigError{Variable: "PAGERDUTY_CONCURRENCY", Err: fmt.Errorf("must be a positive integer, got %q", os.Getenv("PAGERDUTY_CONCURRENCY"))}
+		}
+	}
+	EnvironmentVariables.APIRateLimit = 900
+	if os.Getenv("PAGERDUTY_RATE_LIMIT") != "" {
+		EnvironmentVariables.APIRateLimit, err = strconv.Atoi(os.Getenv("PAGERDUTY_RATE_LIMIT"))
+		if err != nil || EnvironmentVariables.APIRateLimit < 0 {
+			return &ConfigError{Variable: "PAGERDUTY_RATE_LIMIT", Err: fmt.Errorf("must be a non-negative integer, got %q", os.Getenv("PAGERDUTY_RATE_LIMIT"))}
+		}
+	}
+
+	// Seconds before and after now over which schedules are rendered, overrides included
+	EnvironmentVariables.ScheduleHorizon = 14 * 24 * 3600
+	if os.Getenv("SCHEDULE_HORIZON") != "" {
+		EnvironmentVariables.ScheduleHorizon, err = strconv.Atoi(os.Getenv("SCHEDULE_HORIZON"))
+		if err != nil || EnvironmentVariables.ScheduleHorizon <= 0 {
+			return &ConfigError{Variable: "SCHEDULE_HORIZON", Err: fmt.Errorf("must be a positive integer, got %q", os.Getenv("SCHEDULE_HORIZON"))}
+		}
+	}
+
+	// Rows per COPY batch on the bulk write path
+	EnvironmentVariables.BatchSize = 1000
+	if os.Getenv("BULK_BATCH_SIZE") != "" {
+		EnvironmentVariables.BatchSize, err = strconv.Atoi(os.Getenv("BULK_BATCH_SIZE"))
+		if err != nil || EnvironmentVariables.BatchSize <= 0 {
+			return &ConfigError{Variable: "BULK_BATCH_SIZE", Err: fmt.Errorf("must be a positive integer, got %q", os.Getenv("BULK_BATCH_SIZE"))}
+		}
 	}
 
 	// create AWS object and retrieve SSM parameter
 
 	var AWSSession AWS
-	AWSSession.Open("region-id")
+	err = AWSSession.Open("region-id")
+	if err != nil {
+		return err
+	}
 	defer AWSSession.Close()
 
 	fmt.Println("Retrieving SSM Parameter Store entry")
 	response, err := AWSSession.GetParameterValue(EnvironmentVariables.DatabasePasswordParameter)
-
 	if err != nil {
-		log.Fatal(err)
+		return &ConfigError{Variable: "DATABASE_PASSWORD_PARAMETER", Err: err}
 	}
 
 	EnvironmentVariables.DatabasePassword = *response
 
+	return nil
 }
 
 //Open starts AWS connections
@@ -158,12 +508,12 @@ func (a *AWS) Open(region string) error {
 		Config: aws.Config{Region: aws.String(region)},
 	})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	a.session = sess
 
-	return err
+	return nil
 }
 
 //Close destroys all AWS connecitons
@@ -197,7 +547,26 @@ func (a *AWS) GetParameterValue(paramName string) (*string, error) {
 		return nil, err
 	}
 
+	if len(r.Parameters) == 0 {
+		return nil, fmt.Errorf("parameter %s not found", paramName)
+	}
+
 	params := r.Parameters[0]
 
 	return params.Value, err
 }
+
+// InvokeAsync invokes a Lambda function without waiting for its result
+func (a *AWS) InvokeAsync(ctx context.Context, functionName string, payload []byte) error {
+
+	svc := lambda.New(a.session)
+
+	params := &lambda.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	}
+	_, err := svc.InvokeWithContext(ctx, params)
+
+	return err
+}